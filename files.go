@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"github.com/daemonchen/sky-gharchive-importer/importer"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Returns true if an argument names archive files rather than a date: a
+// path with the archive extension or a glob pattern.
+func isFileArg(s string) bool {
+	return strings.HasSuffix(s, config.ArchiveExt) || strings.ContainsAny(s, "*?[")
+}
+
+// Expands file and glob arguments into the hourly archive files they name,
+// by hour. Each file must be named like "2013-01-01-15.json.gz", a pattern
+// must match at least one file, and no two files can hold the same hour.
+func fileArgHours(args []string) (map[time.Time]string, error) {
+	files := map[time.Time]string{}
+	for _, arg := range args {
+		paths, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern: %s", arg)
+		} else if len(paths) == 0 {
+			return nil, fmt.Errorf("No files match %s", arg)
+		}
+		for _, path := range paths {
+			date, ok := importer.ArchiveHour(filepath.ToSlash(path), config.ArchiveExt)
+			if !ok {
+				return nil, fmt.Errorf("Not an hourly archive file: %s (expected YYYY-MM-DD-H%s)", path, config.ArchiveExt)
+			}
+			if other, ok := files[date]; ok && other != path {
+				return nil, fmt.Errorf("Both %s and %s hold %s", other, path, date.Format(time.RFC3339))
+			}
+			files[date] = path
+		}
+	}
+	return files, nil
+}
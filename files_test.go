@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Ensures that file and glob arguments are expanded into the hours their
+// files hold.
+func TestFileArgHours(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"2014-01-01-0.json.gz", "2014-01-01-13.json.gz", "2014-01-02-0.json.gz", "notes.json.gz"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hour := func(day, h int) time.Time { return time.Date(2014, 1, day, h, 0, 0, 0, time.UTC) }
+
+	var tests = []struct {
+		args  []string
+		hours map[time.Time]string
+		err   string
+	}{
+		{[]string{filepath.Join(dir, "2014-01-01-*.json.gz")}, map[time.Time]string{hour(1, 0): "2014-01-01-0.json.gz", hour(1, 13): "2014-01-01-13.json.gz"}, ""},
+		{[]string{filepath.Join(dir, "2014-01-02-0.json.gz"), filepath.Join(dir, "2014-01-0[2]-*.json.gz")}, map[time.Time]string{hour(2, 0): "2014-01-02-0.json.gz"}, ""},
+		{[]string{filepath.Join(dir, "2014-02-*.json.gz")}, nil, "No files match"},
+		{[]string{filepath.Join(dir, "*.json.gz")}, nil, "Not an hourly archive file"},
+	}
+	for _, tt := range tests {
+		files, err := fileArgHours(tt.args)
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("%v: expected %q, got %v", tt.args, tt.err, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		if len(files) != len(tt.hours) {
+			t.Errorf("%v: got %v", tt.args, files)
+		}
+		for date, name := range tt.hours {
+			if files[date] != filepath.Join(dir, name) {
+				t.Errorf("%v: %s: got %q, expected %s", tt.args, date.Format(time.RFC3339), files[date], name)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"github.com/daemonchen/sky-gharchive-importer/importer"
+	"github.com/skydb/sky.go"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// The proxy all requests to the archive go through, from -proxy.
+var requestProxy proxyFlag
+
+// The properties changed or added by -property.
+var propertyOverrides propertyFlag
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// headerFlag collects the repeatable -header option into a set of headers.
+type headerFlag http.Header
+
+// proxyFlag is the -proxy option, a proxy URL that leaves out its password
+// when printed.
+type proxyFlag struct {
+	*url.URL
+}
+
+// propertyFlag collects the repeatable -property option. Each value is
+// "name:type:transient", with an optional ":path" to add a property that
+// isn't in the schema.
+type propertyFlag []*importer.Property
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Returns the header names, leaving out values such as tokens.
+func (f headerFlag) String() string {
+	var names []string
+	for name := range f {
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Adds a header given as "Name: value".
+func (f headerFlag) Set(s string) error {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("expected \"Name: value\"")
+	}
+	http.Header(f).Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	return nil
+}
+
+// Returns the proxy URL with any password redacted.
+func (f *proxyFlag) String() string {
+	if f == nil || f.URL == nil {
+		return ""
+	}
+	return f.URL.Redacted()
+}
+
+// Sets the proxy from an http://, https:// or socks5:// URL.
+func (f *proxyFlag) Set(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("expected an http://, https:// or socks5:// URL")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("proxy host required")
+	}
+	f.URL = u
+	return nil
+}
+
+// Returns the overridden property names.
+func (f *propertyFlag) String() string {
+	var names []string
+	for _, p := range *f {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Adds an override given as "name:type:transient" or
+// "name:type:transient:path".
+func (f *propertyFlag) Set(s string) error {
+	parts := strings.SplitN(s, ":", 4)
+	if len(parts) < 3 {
+		return fmt.Errorf("expected \"name:type:transient\"")
+	}
+	p := &importer.Property{Name: strings.TrimSpace(parts[0]), Type: strings.TrimSpace(parts[1])}
+	if len(parts) == 4 {
+		p.Path = strings.TrimSpace(parts[3])
+	}
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("property name required")
+	case p.Name == "username":
+		return fmt.Errorf("username can't be changed")
+	}
+	switch p.DataType() {
+	case sky.String, sky.Factor, sky.Integer, sky.Float, sky.Boolean:
+	default:
+		return fmt.Errorf("unknown type for %s: %s", p.Name, p.Type)
+	}
+	transient, err := strconv.ParseBool(strings.TrimSpace(parts[2]))
+	if err != nil {
+		return fmt.Errorf("invalid transient flag for %s: %s", p.Name, parts[2])
+	}
+	p.Transient = transient
+	*f = append(*f, p)
+	return nil
+}
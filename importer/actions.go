@@ -0,0 +1,167 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/skydb/sky.go"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// actionCounter tallies the events of each type per user over a run. Every
+// user seen is held in memory until the end, so it costs roughly 100 bytes
+// per user plus 50 bytes for each type the user has. It is safe for
+// concurrent use.
+type actionCounter struct {
+	mutex  sync.Mutex
+	users  map[string]map[string]int
+	types  map[string]string
+	latest time.Time
+}
+
+// actionCounts is a user's tallies as written to a file.
+type actionCounts struct {
+	Username string         `json:"username"`
+	Counts   map[string]int `json:"counts"`
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+func newActionCounter() *actionCounter {
+	return &actionCounter{users: map[string]map[string]int{}, types: map[string]string{}}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Counts an event of a type for a user. A nil counter does nothing.
+func (c *actionCounter) add(username string, action string, timestamp time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Share one copy of each type name between users.
+	if t, ok := c.types[action]; ok {
+		action = t
+	} else {
+		c.types[action] = action
+	}
+
+	counts := c.users[username]
+	if counts == nil {
+		counts = map[string]int{}
+		c.users[username] = counts
+	}
+	counts[action]++
+	if timestamp.After(c.latest) {
+		c.latest = timestamp
+	}
+}
+
+// Writes the tallies to a file as one JSON object per user, ordered by
+// username.
+func (c *actionCounter) writeFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, username := range c.usernames() {
+		if err = encoder.Encode(&actionCounts{username, c.users[username]}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Adds the tallies to Sky as one event per user, an hour after the latest
+// event counted, with a "count_" property for each type. The properties
+// are created first if the table doesn't have them. Returns once every
+// event has been written.
+func (c *actionCounter) addEvents(s *streamer, table *sky.Table) error {
+	if table != nil {
+		existing, err := table.GetProperties()
+		if err != nil {
+			return err
+		}
+		names := map[string]bool{}
+		for _, p := range existing {
+			names[p.Name] = true
+		}
+		for _, t := range c.typeNames() {
+			if name := countPropertyName(t); !names[name] {
+				if err = table.CreateProperty(sky.NewProperty(name, true, sky.Integer)); err != nil {
+					return err
+				}
+				info("Created property %s (%s, transient: %v).", name, sky.Integer, true)
+			}
+		}
+	}
+
+	timestamp := c.latest.Truncate(time.Hour).Add(time.Hour)
+	var done sync.WaitGroup
+	for _, username := range c.usernames() {
+		data := map[string]interface{}{}
+		for t, n := range c.users[username] {
+			data[countPropertyName(t)] = n
+		}
+		s.add(&userEvent{username: username, event: sky.NewEvent(timestamp, data)}, &done)
+	}
+	done.Wait()
+	return nil
+}
+
+// Returns the users counted, in order.
+func (c *actionCounter) usernames() []string {
+	usernames := make([]string, 0, len(c.users))
+	for username := range c.users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// Returns the types counted, in order.
+func (c *actionCounter) typeNames() []string {
+	var types []string
+	for t := range c.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Returns the name of the property holding the tally for a type.
+func countPropertyName(action string) string {
+	return "count_" + action
+}
@@ -0,0 +1,679 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The extensions of the files an archive's ETag and Last-Modified time are
+// saved in next to it.
+const (
+	etagExt         = ".etag"
+	lastModifiedExt = ".last-modified"
+)
+
+// The longest wait between retries, however many attempts have failed.
+const maxRetryDelay = 5 * time.Minute
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+// Returned when an hour has not been published to the archive.
+var errArchiveNotFound = errors.New("Archive not found.")
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// archiveReader is a decompressed archive stream that closes its
+// underlying source along with the decompressor.
+type archiveReader struct {
+	io.ReadCloser
+	source io.Closer
+	digest *digestReader
+}
+
+// resumingBody is the body of an archive download that picks up where it
+// left off with a Range request if the transfer fails partway through.
+type resumingBody struct {
+	ctx       context.Context
+	url       string
+	body      io.ReadCloser
+	validator string
+	offset    int64
+	resumes   int
+}
+
+// tempSource closes an archive downloaded by -verify-download and removes
+// the temporary directory it was downloaded to.
+type tempSource struct {
+	io.Closer
+	dir string
+}
+
+// digestReader hashes a compressed stream as it is read so an archive's
+// checksum is known without reading it twice.
+type digestReader struct {
+	r    io.Reader
+	hash hash.Hash
+	size int64
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Returns the SHA-256 checksum and size of the compressed archive, reading
+// whatever the decompressor left unread first.
+func (r *archiveReader) checksum() (string, int64, error) {
+	if _, err := io.Copy(ioutil.Discard, r.digest); err != nil {
+		return "", 0, err
+	}
+	return r.digest.sum(), r.digest.size, nil
+}
+
+// Closes the decompressor and the underlying source.
+func (r *archiveReader) Close() error {
+	err := r.ReadCloser.Close()
+	if sourceErr := r.source.Close(); err == nil {
+		err = sourceErr
+	}
+	return err
+}
+
+// Reads from the download. A failed read is retried from where it stopped
+// if the download can be resumed, otherwise the error is returned.
+func (b *resumingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+	if err != nil && err != io.EOF && b.resume(err) {
+		err = nil
+	}
+	return n, err
+}
+
+// Closes the current response body.
+func (b *resumingBody) Close() error {
+	return b.body.Close()
+}
+
+// Reopens the download from the current offset after a failed read, with
+// the same backoff as a failed download and up to -retries times per
+// download. Returns false if the server can't resume it.
+func (b *resumingBody) resume(cause error) bool {
+	for b.resumes < config.Retries && b.ctx.Err() == nil {
+		delay := retryDelay(b.resumes)
+		b.resumes++
+		logFields{"url": b.url, "offset": b.offset, "error": cause}.warn("Download interrupted, resuming in %v (%d/%d).", delay, b.resumes, config.Retries)
+		if !sleepContext(b.ctx, delay) {
+			return false
+		}
+
+		body, retryable, err := b.reopen()
+		if err == nil {
+			b.body.Close()
+			b.body = body
+			return true
+		} else if !retryable {
+			logFields{"url": b.url, "error": err}.warn("Unable to resume download.")
+			return false
+		}
+		cause = err
+	}
+	return false
+}
+
+// Requests the rest of the download from the current offset. The request
+// is conditional on the file being unchanged, so a server that replaced it
+// sends the whole file instead, which can't be used.
+func (b *resumingBody) reopen() (io.ReadCloser, bool, error) {
+	req, err := http.NewRequestWithContext(b.ctx, "GET", b.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.offset))
+	if b.validator != "" {
+		req.Header.Set("If-Range", b.validator)
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); ok && start == b.offset {
+			return readTimeout(resp.Body), false, nil
+		}
+		resp.Body.Close()
+		return nil, false, fmt.Errorf("Unexpected content range: %q", resp.Header.Get("Content-Range"))
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return nil, true, errors.New(resp.Status)
+	}
+	return nil, false, fmt.Errorf("Range not honored, the file may have changed: %s", resp.Status)
+}
+
+// Closes the archive file and removes its directory.
+func (s *tempSource) Close() error {
+	err := s.Closer.Close()
+	os.RemoveAll(s.dir)
+	return err
+}
+
+// Reads from the stream, hashing what was read.
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	return n, err
+}
+
+// Returns the hex encoded hash of everything read so far.
+func (r *digestReader) sum() string {
+	return hex.EncodeToString(r.hash.Sum(nil))
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Returns the archive file name for a given hour.
+func archiveName(date time.Time) string {
+	return fmt.Sprintf("%d-%02d-%02d-%d%s", date.Year(), int(date.Month()), date.Day(), date.Hour(), config.ArchiveExt)
+}
+
+// Returns the archive URL for a given hour, or the path of the local file
+// when reading from file arguments or a source directory.
+func archiveURL(date time.Time) string {
+	if path, ok := config.LocalFiles[date]; ok {
+		return path
+	} else if config.SourceDir != "" {
+		return filepath.Join(config.SourceDir, archiveName(date))
+	}
+	return expandArchiveURL(config.BaseURL, date)
+}
+
+// Returns true if hours are read from local files, from -source-dir or
+// file arguments, rather than downloaded.
+func readingLocalFiles() bool {
+	return config.SourceDir != "" || config.LocalFiles != nil
+}
+
+// Returns the URL of an hour's file. A base URL is the directory holding
+// the files, while a template has {file}, {year}, {month}, {day} and
+// {hour} replaced with the file name and the parts of the hour as they
+// appear in it.
+func expandArchiveURL(base string, date time.Time) string {
+	if !isURLTemplate(base) {
+		return strings.TrimRight(base, "/") + "/" + archiveName(date)
+	}
+	return strings.NewReplacer(
+		"{file}", archiveName(date),
+		"{year}", fmt.Sprintf("%d", date.Year()),
+		"{month}", fmt.Sprintf("%02d", int(date.Month())),
+		"{day}", fmt.Sprintf("%02d", date.Day()),
+		"{hour}", fmt.Sprintf("%d", date.Hour()),
+	).Replace(base)
+}
+
+// Returns true if an archive URL is a template rather than a base URL.
+func isURLTemplate(s string) bool {
+	return strings.Contains(s, "{")
+}
+
+// Returns how long to wait before retrying a download: -retry-base-delay
+// doubled for each earlier attempt, up to maxRetryDelay, less up to half
+// of that at random so that workers which failed together don't all retry
+// at the same moment.
+func retryDelay(attempt int) time.Duration {
+	delay := config.RetryBaseDelay
+	if delay <= 0 {
+		return 0
+	}
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay - time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Checks that the archive base URL is an absolute HTTP or HTTPS URL. A
+// template must only use known placeholders and give every hour its own
+// URL. Templates can have a query string, such as for a signed URL.
+func validateBaseURL(s string) error {
+	template := isURLTemplate(s)
+	if template {
+		date := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+		expanded := expandArchiveURL(s, date)
+		if strings.ContainsAny(expanded, "{}") {
+			return fmt.Errorf("Invalid archive URL template: %s (unknown placeholder)", s)
+		}
+		for _, other := range []time.Time{date.Add(time.Hour), date.AddDate(0, 0, 1), date.AddDate(0, 1, 0), date.AddDate(1, 0, 0)} {
+			if expandArchiveURL(s, other) == expanded {
+				return fmt.Errorf("Invalid archive URL template: %s (expected {file} or every part of the hour)", s)
+			}
+		}
+		s = expanded
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("Invalid base URL: %v", err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid base URL: %s (expected http:// or https://)", s)
+	} else if (u.RawQuery != "" && !template) || u.Fragment != "" {
+		return fmt.Errorf("Invalid base URL: %s (unexpected query or fragment)", s)
+	}
+	return nil
+}
+
+// Retrieves the archive for a given hour and returns a reader over the
+// decompressed JSON lines. Network errors and server errors are retried
+// with exponential backoff and jitter. Local files take precedence over
+// the archive server. Cancelling the context aborts the download.
+//
+// With a cache directory, a cached copy of the hour is read if there is
+// one. Otherwise the archive is downloaded into the cache, unless cache
+// writes are disabled, and read from there. With -cache-revalidate a
+// cached copy is only read once the archive confirms it hasn't changed,
+// or with a warning if the archive fails to confirm it in any way, even
+// by no longer having the file. With
+// -verify-download an archive that isn't cached is downloaded in full to
+// a temporary file first. Files are checked against -checksums before
+// they're read.
+func openArchive(ctx context.Context, date time.Time) (io.ReadCloser, error) {
+	name := archiveName(date)
+	if readingLocalFiles() {
+		path := archiveURL(date)
+		if err := verifyChecksum(path, filepath.Base(path)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return openLocalArchive(path)
+	}
+
+	url := archiveURL(date)
+	fetch := func() (io.ReadCloser, int, bool, error) {
+		return fetchArchive(ctx, url)
+	}
+	var path string
+	revalidating := false
+	if config.CacheDir != "" {
+		cached := filepath.Join(config.CacheDir, name)
+		if _, err := os.Stat(cached); err == nil {
+			if err = verifyChecksum(cached, name); err != nil {
+				logFields{"path": cached, "error": err}.warn("Cached copy is corrupt, downloading it again.")
+				if !config.NoCacheWrite {
+					os.Remove(cached)
+				}
+			} else if !config.CacheRevalidate || config.NoCacheWrite {
+				return openLocalArchive(cached)
+			} else {
+				revalidating = true
+			}
+		}
+		if !config.NoCacheWrite {
+			path = cached
+		}
+	}
+	if path == "" && config.VerifyDownload {
+		dir, err := ioutil.TempDir("", "sky-gharchive-")
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, name)
+		opened := false
+		defer func() {
+			if !opened {
+				os.RemoveAll(dir)
+			}
+		}()
+		fetch = func() (io.ReadCloser, int, bool, error) {
+			r, status, retryable, err := downloadVerified(ctx, url, path, name)
+			if err == nil {
+				r.(*archiveReader).source = &tempSource{r.(*archiveReader).source, dir}
+				opened = true
+			}
+			return r, status, retryable, err
+		}
+	} else if path != "" {
+		fetch = func() (io.ReadCloser, int, bool, error) {
+			return downloadVerified(ctx, url, path, name)
+		}
+	}
+
+	var err error
+	var status int
+	for attempt := 0; ; attempt++ {
+		var r io.ReadCloser
+		var retryable bool
+		if r, status, retryable, err = fetch(); err == nil {
+			return r, nil
+		} else if err == errArchiveNotFound && !revalidating {
+			return nil, err
+		} else if !retryable || attempt >= config.Retries || ctx.Err() != nil {
+			break
+		}
+
+		delay := retryDelay(attempt)
+		logFields{"url": url, "error": err}.warn("Retrying in %v (%d/%d).", delay, attempt+1, config.Retries)
+		if !sleepContext(ctx, delay) {
+			break
+		}
+	}
+
+	// Only a changed file replaces a cached copy; one the archive couldn't
+	// confirm is still better than failing or skipping the hour.
+	if revalidating && ctx.Err() == nil {
+		if _, statErr := os.Stat(path); statErr == nil {
+			logFields{"url": url, "error": err}.warn("Unable to revalidate cached copy, reading it anyway.")
+			return openLocalArchive(path)
+		}
+	}
+
+	if err == errArchiveNotFound {
+		return nil, err
+	} else if isTimeout(err) {
+		return nil, fmt.Errorf("Timed out fetching %s after %v: %w", url, config.HTTPTimeout, err)
+	} else if status == 0 {
+		return nil, fmt.Errorf("Unable to fetch %s: %w", url, err)
+	}
+	return nil, fmt.Errorf("Unable to fetch %s (status %d): %w", url, status, err)
+}
+
+// Makes a single attempt at retrieving an archive. Returns the HTTP status
+// code, if a response was received, and whether a failure is worth
+// retrying. A transfer that fails partway through is resumed where the
+// server allows it.
+func fetchArchive(ctx context.Context, url string) (io.ReadCloser, int, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, 0, true, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, resp.StatusCode, false, errArchiveNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = errors.New(resp.Status)
+		return nil, resp.StatusCode, resp.StatusCode >= 500, err
+	}
+	logFields{"url": url}.info("Downloading.")
+
+	// Resume a transfer that fails partway through if the server takes
+	// Range requests, rather than downloading the whole file again.
+	body := readTimeout(resp.Body)
+	if resp.Header.Get("Accept-Ranges") == "bytes" {
+		validator := resp.Header.Get("ETag")
+		if validator == "" || strings.HasPrefix(validator, "W/") {
+			validator = resp.Header.Get("Last-Modified")
+		}
+		body = &resumingBody{ctx: ctx, url: url, body: body, validator: validator}
+	}
+	body = throttle(ctx, body)
+
+	digest := newDigestReader(body)
+	r, err := decompress(digest, url)
+	if err != nil {
+		body.Close()
+		return nil, resp.StatusCode, true, err
+	}
+	return &archiveReader{r, body, digest}, resp.StatusCode, false, nil
+}
+
+// Makes a single attempt at downloading an archive to a file. The archive
+// is written to a partial file next to the path that is only renamed to
+// the path once it has been completely downloaded. If an earlier attempt
+// left a partial file, the download resumes from its end with a Range
+// request, falling back to a clean download if the server doesn't honor
+// it. Returns the HTTP status code and whether a failure is worth
+// retrying, as for fetchArchive.
+//
+// If the file is already there the request is conditional on the archive
+// having changed since, by the ETag and Last-Modified time saved alongside
+// the file, and the file is kept if the archive responds that it hasn't.
+func downloadArchive(ctx context.Context, url string, path string) (int, bool, error) {
+	partial := path + ".part"
+	var offset int64
+	if info, err := os.Stat(partial); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	conditional := false
+	if _, err := os.Stat(path); err == nil {
+		if etag, err := ioutil.ReadFile(path + etagExt); err == nil && len(etag) > 0 {
+			req.Header.Set("If-None-Match", string(etag))
+			conditional = true
+		}
+		if modified, err := ioutil.ReadFile(path + lastModifiedExt); err == nil && len(modified) > 0 {
+			req.Header.Set("If-Modified-Since", string(modified))
+			conditional = true
+		}
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && conditional:
+		logFields{"url": url}.debug("Cached copy is current.")
+		return resp.StatusCode, false, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return resp.StatusCode, false, errArchiveNotFound
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		os.Remove(partial)
+		return resp.StatusCode, true, errors.New(resp.Status)
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			os.Remove(partial)
+			return resp.StatusCode, true, fmt.Errorf("Unexpected content range: %q", resp.Header.Get("Content-Range"))
+		}
+		logFields{"url": url, "offset": offset}.info("Resuming download.")
+	case resp.StatusCode == http.StatusOK:
+		// The server sent the whole file, even if part was asked for.
+		offset = 0
+		logFields{"url": url}.info("Downloading.")
+	default:
+		return resp.StatusCode, resp.StatusCode >= 500, errors.New(resp.Status)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return resp.StatusCode, false, err
+	}
+	n, err := io.Copy(file, throttle(ctx, readTimeout(resp.Body)))
+	if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		// Keep what was downloaded to resume from if the server takes
+		// Range requests.
+		file.Close()
+		if resp.StatusCode != http.StatusPartialContent && resp.Header.Get("Accept-Ranges") != "bytes" {
+			os.Remove(partial)
+		}
+		return resp.StatusCode, true, err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(partial)
+		return resp.StatusCode, false, err
+	}
+	if err = os.Rename(partial, path); err != nil {
+		os.Remove(partial)
+		return resp.StatusCode, false, err
+	}
+
+	// Keep the ETag and Last-Modified time to check the file against on
+	// later runs.
+	saveValidator(path+etagExt, resp.Header.Get("ETag"))
+	saveValidator(path+lastModifiedExt, resp.Header.Get("Last-Modified"))
+	return resp.StatusCode, false, nil
+}
+
+// Saves a response header a later request can be made conditional on, or
+// removes the file if the response didn't have one.
+func saveValidator(path string, value string) {
+	if value == "" {
+		os.Remove(path)
+	} else if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+		logFields{"path": path, "error": err}.warn("Unable to save cache validator.")
+	}
+}
+
+// Downloads an archive to a file, checks it against -checksums and opens
+// it. A file that doesn't match is removed so the retry starts over.
+// Returns the HTTP status code and whether a failure is worth retrying, as
+// for fetchArchive.
+func downloadVerified(ctx context.Context, url string, path string, name string) (io.ReadCloser, int, bool, error) {
+	if status, retryable, err := downloadArchive(ctx, url, path); err != nil {
+		return nil, status, retryable, err
+	}
+	if err := verifyChecksum(path, name); err != nil {
+		os.Remove(path)
+		return nil, http.StatusOK, true, err
+	}
+	r, err := openLocalArchive(path)
+	return r, http.StatusOK, false, err
+}
+
+// Returns the first byte of a Content-Range header such as
+// "bytes 100-999/1000".
+func contentRangeStart(s string) (int64, bool) {
+	var start int64
+	if _, err := fmt.Sscanf(s, "bytes %d-", &start); err != nil {
+		return 0, false
+	}
+	return start, true
+}
+
+// Opens an archive from the local filesystem. A missing file is treated
+// the same as an hour missing from the archive server.
+func openLocalArchive(path string) (io.ReadCloser, error) {
+	logFields{"path": path}.debug("Reading local file.")
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errArchiveNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	digest := newDigestReader(file)
+	r, err := decompress(digest, path)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Unable to read %s: %w", path, err)
+	}
+	return &archiveReader{r, file, digest}, nil
+}
+
+// Returns a reader that hashes a compressed stream as it is read.
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{r: r, hash: sha256.New()}
+}
+
+// Returns a decompressing reader over an archive. The format is detected
+// from the stream's magic number, falling back to the file extension.
+func decompress(r io.Reader, name string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return newGzipReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return newZstdReader(br)
+	case strings.HasSuffix(name, ".zst"):
+		return newZstdReader(br)
+	default:
+		return newGzipReader(br)
+	}
+}
+
+// Returns a gzip reader, or an error if the stream doesn't start with a
+// valid gzip header, such as when a server returns an uncompressed file.
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid gzip archive: %w", err)
+	}
+	return gz, nil
+}
+
+// Returns true if reading an archive failed because it ended early or its
+// checksum didn't match, which usually means the download was cut off.
+func isTruncated(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, gzip.ErrChecksum)
+}
+
+// Returns a zstd reader that releases its decoder when closed.
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
@@ -0,0 +1,410 @@
+package importer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Ensures that a partial download is resumed, or replaced when the server
+// ignores the range.
+func TestDownloadArchiveResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"ranges", func(w http.ResponseWriter, req *http.Request) {
+			http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(content))
+		}},
+		{"no ranges", func(w http.ResponseWriter, req *http.Request) {
+			w.Write(content)
+		}},
+	}
+	for _, tt := range tests {
+		var rangeHeader string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			rangeHeader = req.Header.Get("Range")
+			tt.handler(w, req)
+		}))
+
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "2013-01-01-0.json.gz")
+		if err := ioutil.WriteFile(path+".part", content[:4000], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := downloadArchive(context.Background(), server.URL, path); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if b, _ := ioutil.ReadFile(path); !bytes.Equal(b, content) {
+			t.Errorf("%s: unexpected content: %d bytes", tt.name, len(b))
+		}
+		if rangeHeader != "bytes=4000-" {
+			t.Errorf("%s: unexpected range: %q", tt.name, rangeHeader)
+		}
+		if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+			t.Errorf("%s: partial file left behind", tt.name)
+		}
+
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Ensures that -cache-revalidate keeps a cached hour the archive reports
+// as unchanged, downloads it again once its ETag changes and reads it
+// anyway when the archive fails to confirm it, even with a 404 or another
+// error that isn't retried.
+func TestOpenArchiveRevalidate(t *testing.T) {
+	defer func(u, c string, r bool, n int) {
+		config.BaseURL, config.CacheDir, config.CacheRevalidate, config.Retries = u, c, r, n
+	}(config.BaseURL, config.CacheDir, config.CacheRevalidate, config.Retries)
+
+	gzipped := func(s string) []byte {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		w.Write([]byte(s))
+		w.Close()
+		return b.Bytes()
+	}
+	etag, content := `"v1"`, gzipped("{\"v\":1}\n")
+	modified := time.Date(2013, 1, 1, 2, 0, 0, 0, time.UTC)
+	var statuses []int
+	var ifModifiedSince []string
+	failure := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ifModifiedSince = append(ifModifiedSince, req.Header.Get("If-Modified-Since"))
+		if failure != 0 {
+			http.Error(w, http.StatusText(failure), failure)
+			statuses = append(statuses, failure)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		http.ServeContent(rec, req, "", modified, bytes.NewReader(content))
+		statuses = append(statuses, rec.status)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.BaseURL, config.CacheDir, config.CacheRevalidate, config.Retries = server.URL, dir, true, 0
+
+	date := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	read := func() string {
+		r, err := openArchive(context.Background(), date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		b, _ := ioutil.ReadAll(r)
+		return string(b)
+	}
+	first, second := read(), read()
+	etag, content = `"v2"`, gzipped("{\"v\":2}\n")
+	third := read()
+	var fallbacks []string
+	for _, failure = range []int{http.StatusServiceUnavailable, http.StatusNotFound, http.StatusForbidden} {
+		fallbacks = append(fallbacks, read())
+	}
+
+	if first != "{\"v\":1}\n" || second != first || third != "{\"v\":2}\n" {
+		t.Errorf("unexpected content: %q, %q, %q", first, second, third)
+	}
+	for _, fallback := range fallbacks {
+		if fallback != third {
+			t.Errorf("unexpected content after a failed revalidation: %q", fallback)
+		}
+	}
+	if fmt.Sprint(statuses) != "[200 304 200 503 404 403]" {
+		t.Errorf("unexpected statuses: %v", statuses)
+	}
+	path := filepath.Join(dir, archiveName(date))
+	if b, _ := ioutil.ReadFile(path + etagExt); string(b) != `"v2"` {
+		t.Errorf("unexpected saved ETag: %q", b)
+	}
+
+	// The archive's own Last-Modified time is sent back, not the file's.
+	if err := os.Chtimes(path, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	failure = 0
+	read()
+	if expected := modified.Format(http.TimeFormat); ifModifiedSince[len(ifModifiedSince)-1] != expected {
+		t.Errorf("unexpected If-Modified-Since: %q, expected %q", ifModifiedSince[len(ifModifiedSince)-1], expected)
+	}
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Ensures that a streamed download that fails partway through is resumed
+// with a Range request, and fails as truncated when the file has changed.
+func TestFetchArchiveResume(t *testing.T) {
+	defer func(d time.Duration) { config.RetryBaseDelay = d }(config.RetryBaseDelay)
+	config.RetryBaseDelay = time.Millisecond
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(w, "{\"id\":%d}\n", i)
+	}
+	w.Close()
+	content := gz.Bytes()
+
+	for _, changed := range []bool{false, true} {
+		var ranges []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ranges = append(ranges, req.Header.Get("Range"))
+			w.Header().Set("ETag", `"v1"`)
+			if req.Header.Get("Range") == "" {
+				// Send part of the file and drop the connection.
+				w.Header().Set("Accept-Ranges", "bytes")
+				w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+				w.Write(content[:len(content)/2])
+				return
+			}
+			if changed {
+				w.Header().Set("ETag", `"v2"`)
+			}
+			http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(content))
+		}))
+
+		r, _, _, err := fetchArchive(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("changed %v: %v", changed, err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		server.Close()
+
+		if changed {
+			if !isTruncated(err) {
+				t.Errorf("changed: expected a truncated archive, got %v", err)
+			}
+			continue
+		}
+		if err != nil || bytes.Count(b, []byte("\n")) != 1000 {
+			t.Errorf("unchanged: read %d lines: %v", bytes.Count(b, []byte("\n")), err)
+		}
+		if len(ranges) != 2 || ranges[1] != fmt.Sprintf("bytes=%d-", len(content)/2) {
+			t.Errorf("unchanged: unexpected requests: %q", ranges)
+		}
+	}
+}
+
+// Ensures that -verify-download downloads an hour in full before reading
+// it, cleans up after it and retries a download that doesn't match
+// -checksums before giving up.
+func TestOpenArchiveVerified(t *testing.T) {
+	defer func(u string, v bool, c map[string]string, n int, d time.Duration) {
+		config.BaseURL, config.VerifyDownload, checksums, config.Retries, config.RetryBaseDelay = u, v, c, n, d
+	}(config.BaseURL, config.VerifyDownload, checksums, config.Retries, config.RetryBaseDelay)
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	fmt.Fprintln(w, `{"id":1}`)
+	w.Close()
+	content := gz.Bytes()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "checksums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sum := sha256.Sum256(content)
+	sums := filepath.Join(dir, "SHA256SUMS")
+	if err := ioutil.WriteFile(sums, []byte("# 2013\n"+hex.EncodeToString(sum[:])+"  mirror/2013-01-01-0.json.gz\n"+strings.Repeat("0", 64)+" *2013-01-01-1.json.gz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if checksums, err = readChecksums(sums); err != nil {
+		t.Fatal(err)
+	}
+	config.BaseURL, config.VerifyDownload, config.Retries, config.RetryBaseDelay = server.URL, true, 1, time.Millisecond
+
+	r, err := openArchive(context.Background(), time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := r.(*archiveReader).source.(*tempSource).dir
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != "{\"id\":1}\n" {
+		t.Fatalf("read %q: %v", b, err)
+	}
+	r.Close()
+	if _, err := os.Stat(temp); !os.IsNotExist(err) {
+		t.Errorf("temporary directory left behind: %s", temp)
+	}
+
+	requests = 0
+	if _, err := openArchive(context.Background(), time.Date(2013, 1, 1, 1, 0, 0, 0, time.UTC)); !errors.Is(err, errChecksumMismatch) {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expected the download to be retried once, got %d requests", requests)
+	}
+
+	// Local files are looked up by their own name, which may be zero-padded.
+	defer func(files map[time.Time]string) { config.LocalFiles = files }(config.LocalFiles)
+	local := filepath.Join(dir, "2013-01-01-05.json.gz")
+	if err := ioutil.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	checksums["2013-01-01-05.json.gz"] = strings.Repeat("0", 64)
+	config.LocalFiles = map[time.Time]string{time.Date(2013, 1, 1, 5, 0, 0, 0, time.UTC): local}
+	if _, err := openArchive(context.Background(), time.Date(2013, 1, 1, 5, 0, 0, 0, time.UTC)); !errors.Is(err, errChecksumMismatch) {
+		t.Errorf("expected a checksum mismatch for a local file, got %v", err)
+	}
+}
+
+// Ensures that base URLs and templates are expanded to each hour's file,
+// and that templates which can't tell hours apart are rejected.
+func TestExpandArchiveURL(t *testing.T) {
+	date := time.Date(2015, 3, 7, 5, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		base string
+		url  string
+		err  bool
+	}{
+		{"https://data.gharchive.org", "https://data.gharchive.org/2015-03-07-5.json.gz", false},
+		{"https://mirror.example.com/archive/", "https://mirror.example.com/archive/2015-03-07-5.json.gz", false},
+		{"https://mirror.example.com/{year}/{month}/{file}?sig=abc", "https://mirror.example.com/2015/03/2015-03-07-5.json.gz?sig=abc", false},
+		{"https://mirror.example.com/{year}{month}{day}/{hour}.gz", "https://mirror.example.com/20150307/5.gz", false},
+		{"https://mirror.example.com/{year}/{month}/{day}.gz", "", true},
+		{"https://mirror.example.com/{date}/{file}", "", true},
+		{"https://mirror.example.com/archive?sig=abc", "", true},
+		{"ftp://mirror.example.com/{file}", "", true},
+	}
+	for _, tt := range tests {
+		if err := validateBaseURL(tt.base); (err != nil) != tt.err {
+			t.Errorf("%s: unexpected validation result: %v", tt.base, err)
+		} else if !tt.err {
+			if url := expandArchiveURL(tt.base, date); url != tt.url {
+				t.Errorf("%s: got %s, expected %s", tt.base, url, tt.url)
+			}
+		}
+	}
+}
+
+// Ensures that each retry waits between half and all of the doubled
+// delay, and that the delay stops growing at maxRetryDelay.
+func TestRetryDelay(t *testing.T) {
+	defer func(d time.Duration) { config.RetryBaseDelay = d }(config.RetryBaseDelay)
+	config.RetryBaseDelay = time.Second
+
+	for attempt := 0; attempt < 4; attempt++ {
+		max := time.Second << uint(attempt)
+		for i := 0; i < 100; i++ {
+			if delay := retryDelay(attempt); delay < max/2 || delay > max {
+				t.Fatalf("attempt %d: delay %v outside [%v, %v]", attempt, delay, max/2, max)
+			}
+		}
+	}
+
+	for _, attempt := range []int{9, 34, 64, 1000} {
+		if delay := retryDelay(attempt); delay < maxRetryDelay/2 || delay > maxRetryDelay {
+			t.Fatalf("attempt %d: delay %v outside [%v, %v]", attempt, delay, maxRetryDelay/2, maxRetryDelay)
+		}
+	}
+
+	config.RetryBaseDelay = 0
+	if delay := retryDelay(2); delay != 0 {
+		t.Fatalf("no base delay: got %v", delay)
+	}
+}
+
+// Downloads a day of hours over TLS with 8 workers, reporting the
+// connections opened per hour with the transport's default idle pool and
+// with one idle connection kept per worker.
+func BenchmarkDownloadConnections(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	var conns int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(content)
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	defer func(c *http.Client, n int) { httpClient, config.Concurrency = c, n }(httpClient, config.Concurrency)
+	config.Concurrency = 8
+	for _, idle := range []int{http.DefaultMaxIdleConnsPerHost, 0} {
+		b.Run(fmt.Sprintf("max-idle-conns-per-host=%d", idle), func(b *testing.B) {
+			config.MaxIdleConns = idle
+			defer func() { config.MaxIdleConns = 0 }()
+			httpClient = newHTTPClient()
+			httpClient.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+			defer httpClient.CloseIdleConnections()
+
+			atomic.StoreInt64(&conns, 0)
+			b.SetBytes(int64(len(content)) * 24)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				hours := make(chan int)
+				var wg sync.WaitGroup
+				for i := 0; i < config.Concurrency; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for range hours {
+							req, _ := http.NewRequest("GET", server.URL, nil)
+							resp, err := doRequest(req)
+							if err != nil {
+								b.Error(err)
+								continue
+							}
+							io.Copy(ioutil.Discard, resp.Body)
+							resp.Body.Close()
+						}
+					}()
+				}
+				for hour := 0; hour < 24; hour++ {
+					hours <- hour
+				}
+				close(hours)
+				wg.Wait()
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&conns))/float64(b.N*24), "conns/hour")
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package importer
+
+import (
+	"github.com/skydb/sky.go"
+	"sort"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// userEvent is an event waiting to be added to a user's timeline. The hour
+// is the archive file it was read from, if any. The original line is only
+// kept when rejected records are being written.
+type userEvent struct {
+	username string
+	event    *sky.Event
+	hour     time.Time
+	line     []byte
+}
+
+// userEvents is a list of pending events sortable by timestamp.
+type userEvents []*userEvent
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+func (s userEvents) Len() int {
+	return len(s)
+}
+
+func (s userEvents) Less(i, j int) bool {
+	return s[i].event.Timestamp.Before(s[j].event.Timestamp)
+}
+
+func (s userEvents) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Queues a list of events for the table. The wait group, if given, tracks
+// when they have been written.
+func commit(s *streamer, events userEvents, done *sync.WaitGroup) {
+	for _, e := range events {
+		s.add(e, done)
+	}
+}
+
+// Commits events in batches of a given size, waiting for each batch to be
+// written before queuing the next. A size of zero commits them all at once.
+func commitBatches(s *streamer, events userEvents, size int) {
+	for len(events) > 0 {
+		n := len(events)
+		if size > 0 && size < n {
+			n = size
+		}
+		var done sync.WaitGroup
+		commit(s, events[:n], &done)
+		done.Wait()
+		events = events[n:]
+	}
+}
+
+// Sorts events by timestamp and commits them one window of event time at
+// a time so that commit boundaries line up with event time.
+func commitWindows(s *streamer, events userEvents, window time.Duration) {
+	sort.Stable(events)
+
+	for len(events) > 0 {
+		start := events[0].event.Timestamp.Truncate(window)
+		end := start.Add(window)
+
+		n := sort.Search(len(events), func(i int) bool {
+			return !events[i].event.Timestamp.Before(end)
+		})
+		debug("Committing %d events for %s - %s", n, start.Format(time.RFC3339), end.Format(time.RFC3339))
+		var done sync.WaitGroup
+		commit(s, events[:n], &done)
+		done.Wait()
+		events = events[n:]
+	}
+}
@@ -0,0 +1,75 @@
+package importer
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// bloomFilter is a probabilistic set of strings. It never reports a
+// string it has seen as unseen but may report an unseen string as seen at
+// roughly the configured false positive rate. It is safe for concurrent
+// use.
+type bloomFilter struct {
+	mutex sync.Mutex
+	bits  []uint64
+	m     uint64
+	k     uint64
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a filter sized for an expected number of items and a target
+// false positive rate.
+func newBloomFilter(capacity int, fpRate float64) *bloomFilter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	n := float64(capacity)
+	m := math.Ceil(-n * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+
+	return &bloomFilter{
+		bits: make([]uint64, (uint64(m)+63)/64),
+		m:    uint64(m),
+		k:    uint64(k),
+	}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Adds a string to the filter and returns true if it was possibly already
+// present.
+func (f *bloomFilter) testAndAdd(s string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	h1, h2 := sum&0xFFFFFFFF, sum>>32|1
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	present := true
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			present = false
+			f.bits[bit/64] |= 1 << (bit % 64)
+		}
+	}
+	return present
+}
@@ -0,0 +1,181 @@
+package importer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+// Returned while reading a bundle to stop between hours.
+var errStopBundle = errors.New("Stopped reading bundle.")
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Imports the hourly files in a tar, tar.gz or zip bundle, such as a month
+// of the archive downloaded as a single file. Entries are imported in the
+// order they appear in the bundle and entries for hours that weren't asked
+// for are skipped. Hours missing from the bundle are recorded as skipped.
+// Returns the number of hours completed and failed, as for importHours.
+func importBundle(ctx context.Context, s *streamer, guard *factorGuard, merger *orderedMerger, manifest *manifestWriter, bundlePath string, dates []time.Time) (int, int, error) {
+	wanted := map[time.Time]bool{}
+	for _, date := range dates {
+		wanted[date] = true
+	}
+
+	tally := &hourTally{}
+	err := readBundle(bundlePath, func(name string, r io.Reader) error {
+		date, ok := bundleEntryHour(name)
+		if !ok || !wanted[date] {
+			return nil
+		}
+		delete(wanted, date)
+
+		tally.record(ctx, runHour(ctx, merger, manifest, date, func(ctx context.Context) (int, error) {
+			digest := newDigestReader(r)
+			archive, err := decompress(digest, name)
+			if err != nil {
+				return 0, err
+			}
+			// Wait for the decoder to finish with the entry before
+			// closing it and moving on to the next one.
+			reader := &stoppableReader{r: archive}
+			defer func() {
+				reader.stop()
+				archive.Close()
+			}()
+			count, err := importRecords(ctx, s, guard, merger, reader, date)
+			if err == nil {
+				if _, err = io.Copy(ioutil.Discard, digest); err == nil {
+					manifest.digest(date, digest.sum(), digest.size)
+				}
+			}
+			return count, err
+		}))
+
+		// Stop between hours so no hour is left partially imported.
+		if stopRequested() {
+			warn("Stopping: %s.", stopReason)
+			return errStopBundle
+		}
+		return nil
+	})
+	if err != nil && err != errStopBundle {
+		return tally.completed, tally.failed, err
+	}
+
+	// Account for the hours that were asked for but not found.
+	for _, date := range dates {
+		if wanted[date] {
+			fields := logFields{"hour": date.Format(time.RFC3339), "bundle": bundlePath}
+			if err == errStopBundle {
+				recordHour(manifest, date, 0, hourSkipped, nil)
+				continue
+			}
+			fields.warn("Hour not in bundle.")
+			recordHour(manifest, date, 0, hourSkipped, errArchiveNotFound)
+			checkpoint.complete(date)
+			tally.completed++
+		}
+	}
+	return tally.completed, tally.failed, tally.abortErr
+}
+
+// Calls fn with the name and contents of each file in a bundle. A zip file
+// is detected by its extension and anything else is read as a tar file,
+// gzip compressed or not. Iteration stops at the first error from fn.
+func readBundle(bundlePath string, fn func(name string, r io.Reader) error) error {
+	if strings.HasSuffix(strings.ToLower(bundlePath), ".zip") {
+		zr, err := zip.OpenReader(bundlePath)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	if magic, _ := r.(*bufio.Reader).Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err := newGzipReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = fn(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Returns the hour of a bundle entry named like an hourly archive file,
+// such as "2013-01-01-15.json.gz" in any directory.
+func bundleEntryHour(name string) (time.Time, bool) {
+	return ArchiveHour(name, config.ArchiveExt)
+}
+
+// ArchiveHour returns the hour of an archive file named like
+// "2013-01-01-15.json.gz", in any directory, with the given extension.
+func ArchiveHour(name string, ext string) (time.Time, bool) {
+	base := path.Base(name)
+	if !strings.HasSuffix(base, ext) {
+		return time.Time{}, false
+	}
+	date, err := time.Parse("2006-01-02-15", strings.TrimSuffix(base, ext))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
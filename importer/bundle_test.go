@@ -0,0 +1,25 @@
+package importer
+
+import (
+	"testing"
+	"time"
+)
+
+// Ensures that bundle entries are matched to hours by name.
+func TestBundleEntryHour(t *testing.T) {
+	tests := []struct {
+		name string
+		date time.Time
+		ok   bool
+	}{
+		{"2013-01-01-5.json.gz", time.Date(2013, 1, 1, 5, 0, 0, 0, time.UTC), true},
+		{"2013-01/2013-01-31-23.json.gz", time.Date(2013, 1, 31, 23, 0, 0, 0, time.UTC), true},
+		{"2013-01-01-5.json", time.Time{}, false},
+		{"README.json.gz", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		if date, ok := bundleEntryHour(tt.name); ok != tt.ok || !date.Equal(tt.date) {
+			t.Errorf("%s: unexpected hour: %v, %v", tt.name, date, ok)
+		}
+	}
+}
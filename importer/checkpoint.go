@@ -0,0 +1,252 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// lineCheckpoint records how far into each unfinished hour every event has
+// been written, so a failed hour can be resumed from the next line rather
+// than imported again from the start. The file is rewritten at most once a
+// second and whenever an hour finishes. It is safe for concurrent use.
+type lineCheckpoint struct {
+	mutex sync.Mutex
+	path  string
+	lines map[time.Time]int
+	saved time.Time
+}
+
+// checkpointWriter records the last hour of a run that has been completely
+// imported. Hours may finish out of order when several are imported at
+// once, so the checkpoint only moves past an hour once every hour before
+// it has finished too. It is safe for concurrent use.
+type checkpointWriter struct {
+	mutex sync.Mutex
+	path  string
+	dates []time.Time
+	done  map[time.Time]bool
+	next  int
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a checkpoint for a run over a list of hours.
+func newCheckpointWriter(path string, dates []time.Time) *checkpointWriter {
+	return &checkpointWriter{path: path, dates: dates, done: map[time.Time]bool{}}
+}
+
+// Reads the line checkpoint at a path, if there is one.
+func newLineCheckpoint(path string) (*lineCheckpoint, error) {
+	c := &lineCheckpoint{path: path, lines: map[time.Time]int{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		var hour string
+		var n int
+		if _, err := fmt.Sscanf(line, "%s %d", &hour, &n); err != nil {
+			return nil, fmt.Errorf("Invalid line checkpoint: %q", line)
+		}
+		date, err := time.Parse(time.RFC3339, hour)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid line checkpoint: %q", line)
+		}
+		c.lines[date.UTC()] = n
+	}
+	return c, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Marks an hour as imported and writes the checkpoint if it moved, saving
+// the dedupe keys first so that a run that is killed can resume without
+// importing duplicates. A nil writer does nothing.
+func (c *checkpointWriter) complete(date time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.done[date] = true
+	last := -1
+	for c.next < len(c.dates) && c.done[c.dates[c.next]] {
+		delete(c.done, c.dates[c.next])
+		last = c.next
+		c.next++
+	}
+	if last < 0 {
+		return
+	}
+
+	if err := dedupe.save(dedupePath(c.path)); err != nil {
+		warn("Unable to save dedupe keys: %v", err)
+	}
+	if err := writeCheckpoint(c.path, c.dates[last]); err != nil {
+		warn("Unable to write checkpoint: %v", err)
+	}
+}
+
+// Returns the last line of an hour written by an earlier attempt, or zero
+// to start from the beginning. A nil checkpoint always returns zero.
+func (c *lineCheckpoint) start(date time.Time) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lines[date]
+}
+
+// Records that every event up to a line of an hour has been written. A nil
+// checkpoint does nothing.
+func (c *lineCheckpoint) record(date time.Time, line int) {
+	if c == nil || date.IsZero() {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lines[date] = line
+	if time.Since(c.saved) >= time.Second {
+		c.save()
+	}
+}
+
+// Forgets an hour once it has been completely imported. A nil checkpoint
+// does nothing.
+func (c *lineCheckpoint) complete(date time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.lines[date]; ok {
+		delete(c.lines, date)
+		c.save()
+	}
+}
+
+// Writes any progress not yet saved. A nil checkpoint does nothing.
+func (c *lineCheckpoint) flush() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.save()
+}
+
+// Writes the file. The caller must hold the lock.
+func (c *lineCheckpoint) save() {
+	var dates []time.Time
+	for date := range c.lines {
+		dates = append(dates, date)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	var b bytes.Buffer
+	for _, date := range dates {
+		fmt.Fprintf(&b, "%s %d\n", date.Format(time.RFC3339), c.lines[date])
+	}
+	if err := WriteFileAtomic(c.path, b.Bytes()); err != nil {
+		warn("Unable to write line checkpoint: %v", err)
+	}
+	c.saved = time.Now()
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Reads the last completed hour from a checkpoint file. Returns a zero
+// time if the file doesn't exist.
+func readCheckpoint(path string) (time.Time, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(b)))
+}
+
+// Writes the last completed hour to a checkpoint file. The file is written
+// to a temporary file first and renamed over the old one so that a crash
+// never leaves a partial checkpoint.
+func writeCheckpoint(path string, date time.Time) error {
+	return WriteFileAtomic(path, []byte(date.UTC().Format(time.RFC3339)+"\n"))
+}
+
+// WriteFileAtomic writes a file by writing a temporary file and renaming it
+// over the path, so a reader never sees a partial file.
+func WriteFileAtomic(path string, b []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(b); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Returns the path of the line checkpoint kept next to a checkpoint.
+func lineCheckpointPath(checkpoint string) string {
+	return checkpoint + ".lines"
+}
+
+// Returns the hours after a checkpoint. If the checkpoint doesn't fall
+// within the hours then they are all returned.
+func resumeAfter(dates []time.Time, last time.Time) []time.Time {
+	if last.IsZero() || len(dates) == 0 || last.Before(dates[0]) || last.After(dates[len(dates)-1]) {
+		return dates
+	}
+
+	var remaining []time.Time
+	for _, date := range dates {
+		if date.After(last) {
+			remaining = append(remaining, date)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,73 @@
+package importer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Ensures that line progress survives a restart and is dropped once the
+// hour completes.
+func TestLineCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.ckpt.lines")
+
+	hour1 := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	hour2 := hour1.Add(time.Hour)
+	c, err := newLineCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.record(hour1, 1000)
+	c.record(hour1, 2000)
+	c.record(hour2, 500)
+	c.flush()
+
+	if c, err = newLineCheckpoint(path); err != nil {
+		t.Fatal(err)
+	} else if n := c.start(hour1); n != 2000 {
+		t.Fatalf("Unexpected start line: %d", n)
+	} else if n := c.start(hour2); n != 500 {
+		t.Fatalf("Unexpected start line: %d", n)
+	}
+
+	c.complete(hour1)
+	if c, err = newLineCheckpoint(path); err != nil {
+		t.Fatal(err)
+	} else if n := c.start(hour1); n != 0 {
+		t.Fatalf("Unexpected start line after completing: %d", n)
+	}
+}
+
+// Ensures that the dedupe keys are saved along with each checkpoint, so
+// that they survive a run that is killed.
+func TestCheckpointSavesDedupe(t *testing.T) {
+	defer func(d *dedupeSet) { dedupe = d }(dedupe)
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.ckpt")
+
+	hour := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	dedupe = newDedupeSet(10)
+	dedupe.testAndAdd("bob", hour, "PushEvent")
+	newCheckpointWriter(path, []time.Time{hour}).complete(hour)
+
+	loaded := newDedupeSet(10)
+	if err := loaded.load(dedupePath(path)); err != nil {
+		t.Fatal(err)
+	} else if !loaded.testAndAdd("bob", hour, "PushEvent") {
+		t.Fatal("Expected the saved key to be loaded.")
+	}
+	if last, err := readCheckpoint(path); err != nil || !last.Equal(hour) {
+		t.Fatalf("Unexpected checkpoint: %v, %v", last, err)
+	}
+}
@@ -0,0 +1,201 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The number of lines handed to a parse worker at a time.
+const parseBatchSize = 256
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+// Returned when reading from a stoppableReader after it was stopped.
+var errReaderStopped = errors.New("Reader stopped.")
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// decodedRecord is an archive line decoded as JSON.
+type decodedRecord struct {
+	lineNumber int
+	line       []byte
+	data       map[string]interface{}
+	err        error
+}
+
+// recordBatch is a run of consecutive lines decoded by a single worker.
+// The read error, if any, occurred after the batch's lines.
+type recordBatch struct {
+	records []*decodedRecord
+	err     error
+	done    chan bool
+}
+
+// recordDecoder reads lines from a reader and decodes them on a pool of
+// parse workers. Records are returned in their original order.
+type recordDecoder struct {
+	order   chan *recordBatch
+	quit    chan bool
+	current *recordBatch
+	index   int
+}
+
+// stoppableReader hands a reader to a decoder and takes it back. Once it
+// is stopped no read is in progress and later reads fail, so the reader
+// underneath can be closed while the decoder is still winding down.
+type stoppableReader struct {
+	mutex   sync.Mutex
+	r       io.Reader
+	stopped bool
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+func newRecordDecoder(r io.Reader, workers int) *recordDecoder {
+	d := &recordDecoder{
+		order: make(chan *recordBatch, workers*2),
+		quit:  make(chan bool),
+	}
+
+	work := make(chan *recordBatch, workers)
+	for i := 0; i < workers; i++ {
+		go decodeBatches(work)
+	}
+	go d.read(bufio.NewReader(r), work)
+
+	return d
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Returns the next record. Returns io.EOF after the last record or the
+// error that stopped the reader.
+func (d *recordDecoder) next() (*decodedRecord, error) {
+	for d.current == nil || d.index >= len(d.current.records) {
+		if d.current != nil && d.current.err != nil {
+			return nil, d.current.err
+		}
+
+		batch, ok := <-d.order
+		if !ok {
+			return nil, io.EOF
+		}
+		<-batch.done
+		d.current, d.index = batch, 0
+	}
+
+	record := d.current.records[d.index]
+	d.index++
+	return record, nil
+}
+
+// Stops reading. Must be called when the caller is done with the decoder.
+func (d *recordDecoder) close() {
+	close(d.quit)
+}
+
+// Reads from the reader unless it has been stopped.
+func (r *stoppableReader) Read(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stopped {
+		return 0, errReaderStopped
+	}
+	return r.r.Read(p)
+}
+
+// Waits for a read in progress to finish and fails any later ones.
+func (r *stoppableReader) stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stopped = true
+}
+
+// Reads lines into batches and hands them to the workers in order.
+func (d *recordDecoder) read(r *bufio.Reader, work chan *recordBatch) {
+	defer close(d.order)
+	defer close(work)
+
+	lineNumber := 0
+	eof := false
+	for !eof {
+		batch := &recordBatch{done: make(chan bool)}
+		for len(batch.records) < parseBatchSize && !eof {
+			line, err := r.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				// A line cut off by a read error is never complete.
+				line = nil
+			}
+			if len(line) > 0 {
+				lineNumber++
+				batch.records = append(batch.records, &decodedRecord{lineNumber: lineNumber, line: line})
+			}
+			if err != nil {
+				if err != io.EOF {
+					batch.err = err
+				}
+				eof = true
+			}
+		}
+		if len(batch.records) == 0 && batch.err == nil {
+			return
+		}
+
+		// Queue the batch for ordering first so it's always waited on.
+		select {
+		case d.order <- batch:
+		case <-d.quit:
+			return
+		}
+		select {
+		case work <- batch:
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Decodes batches until the work channel is closed.
+func decodeBatches(work chan *recordBatch) {
+	for batch := range work {
+		t := time.Now()
+		for _, record := range batch.records {
+			record.data = map[string]interface{}{}
+			record.err = json.Unmarshal(record.line, &record.data)
+		}
+		stats.parsed(len(batch.records), time.Since(t))
+		close(batch.done)
+	}
+}
@@ -0,0 +1,41 @@
+package importer
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// Ensures that stopping a reader waits for the read in progress and fails
+// any later ones.
+func TestStoppableReader(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := &stoppableReader{r: pr}
+
+	read := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 4))
+		read <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	stopped := make(chan bool)
+	go func() {
+		r.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stopped while a read was in progress")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	pw.Write([]byte("data"))
+	if err := <-read; err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	<-stopped
+	if _, err := r.Read(make([]byte, 4)); err != errReaderStopped {
+		t.Fatalf("expected errReaderStopped, got %v", err)
+	}
+}
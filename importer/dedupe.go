@@ -0,0 +1,145 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// dedupeSet remembers the most recent events imported, keyed on the user,
+// timestamp and event type, so that an event seen again is skipped. Only
+// the last window of keys is kept and keys are hashed to bound memory, so
+// it's best-effort: an old event can be imported again and, very rarely,
+// two different events can share a key. It is safe for concurrent use.
+type dedupeSet struct {
+	mutex sync.Mutex
+	keys  map[uint64]bool
+	ring  []uint64
+	next  int
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a set that remembers up to size keys.
+func newDedupeSet(size int) *dedupeSet {
+	return &dedupeSet{keys: make(map[uint64]bool, size), ring: make([]uint64, 0, size)}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Adds an event's key to the set and returns true if it was already
+// present. A nil set never reports a duplicate.
+func (d *dedupeSet) testAndAdd(username string, timestamp time.Time, action string) bool {
+	if d == nil {
+		return false
+	}
+	key := dedupeKey(username, timestamp, action)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.keys[key] {
+		return true
+	}
+	d.add(key)
+	return false
+}
+
+// Adds a key, forgetting the oldest one if the set is full. The caller
+// must hold the lock.
+func (d *dedupeSet) add(key uint64) {
+	if len(d.ring) < cap(d.ring) {
+		d.ring = append(d.ring, key)
+	} else {
+		delete(d.keys, d.ring[d.next])
+		d.ring[d.next] = key
+		d.next = (d.next + 1) % len(d.ring)
+	}
+	d.keys[key] = true
+}
+
+// Adds the keys saved by an earlier run. A missing file is not an error.
+func (d *dedupeSet) load(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, err := strconv.ParseUint(strings.TrimSpace(scanner.Text()), 16, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid dedupe key: %s", scanner.Text())
+		}
+		if !d.keys[key] {
+			d.add(key)
+		}
+	}
+	return scanner.Err()
+}
+
+// Writes the keys to a file, oldest first, replacing it atomically. A nil
+// set does nothing.
+func (d *dedupeSet) save(path string) error {
+	if d == nil {
+		return nil
+	}
+
+	d.mutex.Lock()
+	var b bytes.Buffer
+	for i := range d.ring {
+		fmt.Fprintf(&b, "%016x\n", d.ring[(d.next+i)%len(d.ring)])
+	}
+	d.mutex.Unlock()
+	return WriteFileAtomic(path, b.Bytes())
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Returns the hashed key of an event.
+func dedupeKey(username string, timestamp time.Time, action string) uint64 {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(timestamp.UnixNano()))
+
+	h := fnv.New64a()
+	h.Write([]byte(username))
+	h.Write([]byte{0})
+	h.Write(b[:])
+	h.Write([]byte(action))
+	return h.Sum64()
+}
+
+// Returns the path the dedupe keys are kept at alongside a checkpoint.
+func dedupePath(checkpointPath string) string {
+	return checkpointPath + ".dedupe"
+}
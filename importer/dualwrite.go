@@ -0,0 +1,91 @@
+package importer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// dualWriteCounts counts the events that reached each side of a dual write
+// per event hour so the two can be compared at the end of the run.
+type dualWriteCounts struct {
+	mutex sync.Mutex
+	sky   map[time.Time]int
+	file  map[time.Time]int
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+func newDualWriteCounts() *dualWriteCounts {
+	return &dualWriteCounts{
+		sky:  map[time.Time]int{},
+		file: map[time.Time]int{},
+	}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Records the outcome of writing an event to both sides. A nil counter
+// does nothing.
+func (c *dualWriteCounts) record(timestamp time.Time, toSky bool, toFile bool) {
+	if c == nil {
+		return
+	}
+
+	hour := timestamp.UTC().Truncate(time.Hour)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if toSky {
+		c.sky[hour]++
+	}
+	if toFile {
+		c.file[hour]++
+	}
+}
+
+// Compares the counts for every hour and reports each mismatch. Returns
+// the number of mismatched hours.
+func (c *dualWriteCounts) validate() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	hours := map[time.Time]bool{}
+	for hour := range c.sky {
+		hours[hour] = true
+	}
+	for hour := range c.file {
+		hours[hour] = true
+	}
+
+	var sorted []time.Time
+	for hour := range hours {
+		sorted = append(sorted, hour)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	mismatches := 0
+	for _, hour := range sorted {
+		if c.sky[hour] != c.file[hour] {
+			warn("Dual write mismatch for %s: %d in Sky, %d in file", hour.Format(time.RFC3339), c.sky[hour], c.file[hour])
+			mismatches++
+		}
+	}
+	if mismatches == 0 {
+		info("Dual write validated: %d hours match.", len(sorted))
+	}
+	return mismatches
+}
@@ -0,0 +1,213 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/skydb/sky.go"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+var errFactorOverflow = errors.New("Factor cardinality limit exceeded.")
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// factorGuard tracks the distinct values seen for each Factor property so
+// that a mapping mistake (such as pointing a repository name at a factor)
+// is caught before it floods the table's factor dictionary.
+//
+// The guard also limits the length of string factor values, truncating or
+// dropping anything longer than maxLength. It is safe for concurrent use,
+// with each property's values behind their own lock, which is only taken
+// when there is a limit.
+type factorGuard struct {
+	limit     int
+	abort     bool
+	values    map[string]*factorValues
+	maxLength int
+	dropLong  bool
+	truncated int64
+	dropped   int64
+}
+
+// factorValues is the set of distinct values seen for a factor property.
+type factorValues struct {
+	mutex sync.Mutex
+	seen  map[string]bool
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a guard over the factor properties in a property list. A limit
+// of zero disables the guard.
+func newFactorGuard(properties []*sky.Property, limit int, abort bool) *factorGuard {
+	g := &factorGuard{
+		limit:  limit,
+		abort:  abort,
+		values: map[string]*factorValues{},
+	}
+	for _, property := range properties {
+		if property.DataType == sky.Factor {
+			g.values[property.Name] = &factorValues{seen: map[string]bool{}}
+		}
+	}
+	return g
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Formats numeric factor values as strings, limits the length of factor
+// values and records them. Returns errFactorOverflow if a property exceeds
+// the cardinality limit and the guard is set to abort.
+func (g *factorGuard) check(event *sky.Event) error {
+	if g == nil {
+		return nil
+	}
+
+	for name, values := range g.values {
+		value, ok := event.Data[name]
+		if !ok || value == nil {
+			continue
+		}
+
+		// Numbers used as categories are stored as clean strings.
+		if str, ok := formatFactorNumber(value, config.FactorNumberFormat); ok {
+			value = str
+			event.Data[name] = value
+		}
+
+		// Truncate or drop overly long strings.
+		if str, ok := value.(string); ok && g.maxLength > 0 && len(str) > g.maxLength {
+			if g.dropLong {
+				delete(event.Data, name)
+				atomic.AddInt64(&g.dropped, 1)
+				continue
+			}
+			value = truncateString(str, g.maxLength)
+			event.Data[name] = value
+			atomic.AddInt64(&g.truncated, 1)
+		}
+		if g.limit <= 0 {
+			continue
+		}
+
+		key := fmt.Sprint(value)
+		if values.add(key, g.limit) {
+			warn("Factor property %q exceeded %d distinct values (latest: %q).", name, g.limit, key)
+			if g.abort {
+				return errFactorOverflow
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reports how often long factor values were truncated or dropped.
+func (g *factorGuard) report() {
+	if n := atomic.LoadInt64(&g.truncated); n > 0 {
+		warn("Truncated %d factor values longer than %d bytes.", n, g.maxLength)
+	}
+	if n := atomic.LoadInt64(&g.dropped); n > 0 {
+		warn("Dropped %d factor values longer than %d bytes.", n, g.maxLength)
+	}
+}
+
+// Records a value and returns true if it takes the set past the limit.
+// Values stop being collected once the limit is passed so memory stays
+// bounded.
+func (v *factorValues) add(value string, limit int) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.seen[value] || len(v.seen) > limit {
+		return false
+	}
+	v.seen[value] = true
+	return len(v.seen) > limit
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Formats a numeric factor value as a string. The "plain" format writes
+// the shortest decimal form without trailing zeros or an exponent, "int"
+// drops any fractional part and anything else is used as a fmt verb such
+// as "%.2f". Returns false if the value is not a number.
+func formatFactorNumber(value interface{}, format string) (string, bool) {
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	case int:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	case json.Number:
+		var err error
+		if f, err = v.Float64(); err != nil {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+
+	switch format {
+	case "plain":
+		return strconv.FormatFloat(f, 'f', -1, 64), true
+	case "int":
+		return strconv.FormatFloat(math.Trunc(f), 'f', 0, 64), true
+	default:
+		return fmt.Sprintf(format, f), true
+	}
+}
+
+// Truncates a string to at most n bytes without splitting a UTF-8 sequence.
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Checks that a factor number format is "plain", "int" or a fmt verb for
+// a floating point number.
+func validateFactorNumberFormat(format string) error {
+	if format == "plain" || format == "int" {
+		return nil
+	}
+	if strings.Count(format, "%") != 1 || strings.Contains(fmt.Sprintf(format, 1.0), "%!") {
+		return fmt.Errorf("Invalid factor number format: %s", format)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package importer
+
+import (
+	"fmt"
+	"github.com/skydb/sky.go"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Ensures that the guard counts distinct values per property across
+// concurrent workers and reports the overflow once.
+func TestFactorGuardConcurrent(t *testing.T) {
+	properties := []*sky.Property{{Name: "action", DataType: sky.Factor}, {Name: "language", DataType: sky.Factor}}
+	g := newFactorGuard(properties, 10, true)
+	g.maxLength = 4
+
+	var overflows int64
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				event := &sky.Event{Data: map[string]interface{}{"action": fmt.Sprintf("a%d", (w*100+i)%20), "language": "Go"}}
+				if err := g.check(event); err == errFactorOverflow {
+					atomic.AddInt64(&overflows, 1)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if overflows != 1 {
+		t.Errorf("expected one overflow, got %d", overflows)
+	}
+	if n := len(g.values["language"].seen); n != 1 {
+		t.Errorf("expected one language, got %d", n)
+	}
+	if g.truncated != 0 || g.dropped != 0 {
+		t.Errorf("unexpected truncations: %d, %d", g.truncated, g.dropped)
+	}
+}
@@ -0,0 +1,117 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// The client used for all requests to the archive.
+var httpClient = http.DefaultClient
+
+// Paces requests to the archive when -max-rps is set.
+var requestTicker *time.Ticker
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Creates the client used to fetch archives from the run's options.
+// The timeout covers the whole request, including reading the body.
+// Requests go through the -proxy proxy or, without it, the one set by the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, if any.
+//
+// The client is shared by every worker. The default transport only keeps
+// two idle connections per host, so with more workers than that most
+// downloads would set up a new connection, with its DNS lookup and TLS
+// handshake, and drop it afterwards. By default one idle connection is
+// kept per worker instead.
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if config.Proxy != nil {
+		transport.Proxy = http.ProxyURL(config.Proxy)
+	}
+	transport.MaxIdleConnsPerHost = idleConnsPerHost(config.MaxIdleConns, config.Concurrency)
+	if transport.MaxIdleConns < transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConns = transport.MaxIdleConnsPerHost
+	}
+	transport.IdleConnTimeout = config.IdleConnTimeout
+	client := &http.Client{Transport: transport, CheckRedirect: checkRedirect, Timeout: config.HTTPTimeout}
+
+	// A throttled download can't be read within a fixed time, so only the
+	// wait for the response is timed here and each read of the body by
+	// readTimeout.
+	if bandwidth != nil {
+		transport.ResponseHeaderTimeout = config.HTTPTimeout
+		client.Timeout = 0
+	}
+	return client
+}
+
+// Returns the number of idle connections to keep per host: the number
+// asked for, or one per worker and at least the transport's default.
+func idleConnsPerHost(max int, workers int) int {
+	if max > 0 {
+		return max
+	} else if workers > http.DefaultMaxIdleConnsPerHost {
+		return workers
+	}
+	return http.DefaultMaxIdleConnsPerHost
+}
+
+// Sends a request to the archive with the -header headers, first waiting
+// for its turn when requests are rate limited. Waiting stops if the
+// request's context is cancelled.
+func doRequest(req *http.Request) (*http.Response, error) {
+	for name, values := range config.RequestHeaders {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	if requestTicker != nil {
+		select {
+		case <-requestTicker.C:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	return httpClient.Do(req)
+}
+
+// Returns true if an error is a request or body read timing out.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Limits the number of redirects followed and controls whether the
+// Authorization header is forwarded. By default it is never sent to a host
+// other than the one originally requested, which is the safe behavior for
+// mirrors that redirect to signed CDN URLs.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > config.MaxRedirects {
+		return fmt.Errorf("Stopped after %d redirects", config.MaxRedirects)
+	}
+
+	origin := via[0]
+	if req.URL.Host != origin.URL.Host && config.StripAuthOnRedirect {
+		req.Header.Del("Authorization")
+	} else if auth := origin.Header.Get("Authorization"); auth != "" && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	debug("Redirected to %v", req.URL)
+	return nil
+}
@@ -0,0 +1,32 @@
+package importer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// Ensures that archive requests are routed through the proxy.
+func TestHTTPClientProxy(t *testing.T) {
+	defer func(p *url.URL) { config.Proxy = p }(config.Proxy)
+
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxied = req.URL.String()
+	}))
+	defer proxy.Close()
+
+	var err error
+	if config.Proxy, err = url.Parse(proxy.URL); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := newHTTPClient().Get("http://data.gharchive.invalid/2013-01-01-0.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxied != "http://data.gharchive.invalid/2013-01-01-0.json.gz" {
+		t.Errorf("request not proxied: %q", proxied)
+	}
+}
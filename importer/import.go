@@ -0,0 +1,780 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/skydb/sky.go"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The number of records an hour must have before -max-skip-rate applies, so
+// a few bad lines at the start of a file don't abort the run.
+const minSkipRateSample = 1000
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+// Returned by parseEvent for records of users that weren't selected.
+var errFilteredUser = errors.New("User not selected.")
+
+// Returned when too many of an hour's records can't be imported.
+var errSkipRateExceeded = errors.New("Skip rate exceeded.")
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// hourTally counts the outcomes of the hours in a run. It is safe for
+// concurrent use.
+type hourTally struct {
+	mutex     sync.Mutex
+	completed int
+	failed    int
+	abortErr  error
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Setup
+//--------------------------------------
+
+func setup() (*sky.Client, *sky.Table, error) {
+	info("Connecting to %s:%d.", config.Host, config.Port)
+
+	// Create a Sky client.
+	client := sky.NewClient(config.Host)
+	client.Port = config.Port
+
+	// Check if the server is running.
+	if !client.Ping() {
+		return nil, nil, errors.New("Server is not running.")
+	}
+
+	// Check if the table exists first.
+	table, err := client.GetTable(config.TableName)
+	if table != nil {
+		if config.Overwrite {
+			err = client.DeleteTable(table)
+			if err != nil {
+				return nil, nil, err
+			}
+			table = nil
+		}
+	}
+
+	if table == nil {
+		// Create the table.
+		table = sky.NewTable(config.TableName, client)
+		if err = client.CreateTable(table); err != nil {
+			return nil, nil, err
+		}
+		info("Created table %s.", config.TableName)
+
+		// Add properties.
+		for _, property := range tableProperties() {
+			if err = table.CreateProperty(property); err != nil {
+				return nil, nil, err
+			}
+			info("Created property %s (%s, transient: %v).", property.Name, property.DataType, property.Transient)
+		}
+	} else {
+		info("Using existing table %s.", config.TableName)
+		if err = addMissingProperties(table); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Make sure the table matches the schema before spending hours on it.
+	if config.Preflight {
+		if err = checkTableSchema(table); err != nil {
+			return nil, nil, err
+		}
+		info("Table %s matches the schema.", config.TableName)
+	}
+
+	return client, table, nil
+}
+
+// Brings an existing table up to date with the schema by creating any
+// properties it's missing, such as ones added since the table was created.
+// A property that exists with a different type is reported and, with
+// -preflight, fails setup since its values would be rejected.
+func addMissingProperties(table *sky.Table) error {
+	existing, err := table.GetProperties()
+	if err != nil {
+		return err
+	}
+	byName := map[string]*sky.Property{}
+	for _, p := range existing {
+		byName[p.Name] = p
+	}
+
+	var conflicts []string
+	for _, property := range tableProperties() {
+		current := byName[property.Name]
+		if current == nil {
+			if err = table.CreateProperty(property); err != nil {
+				return err
+			}
+			info("Created missing property %s (%s, transient: %v).", property.Name, property.DataType, property.Transient)
+		} else if !strings.EqualFold(current.DataType, property.DataType) {
+			warn("PROPERTY TYPE MISMATCH: %s is %s in the table but %s in the schema.", property.Name, current.DataType, property.DataType)
+			conflicts = append(conflicts, property.Name)
+		} else if current.Transient != property.Transient {
+			warn("Property %s is transient: %v in the table but transient: %v in the schema.", property.Name, current.Transient, property.Transient)
+		}
+	}
+
+	if len(conflicts) > 0 && config.Preflight {
+		return fmt.Errorf("Table %s has conflicting property types: %s", config.TableName, strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// Fetches the table's properties from the server and fails with a line
+// for each property that is missing or differs from the schema.
+func checkTableSchema(table *sky.Table) error {
+	existing, err := table.GetProperties()
+	if err != nil {
+		return err
+	}
+	if drift := schemaDrift(tableProperties(), existing); len(drift) > 0 {
+		return fmt.Errorf("Table %s doesn't match the schema:\n  %s", config.TableName, strings.Join(drift, "\n  "))
+	}
+	return nil
+}
+
+// Compares the properties a table has with the ones expected and describes
+// each expected property that is missing or has a different type or
+// transient flag. Extra properties on the table are ignored.
+func schemaDrift(expected []*sky.Property, existing []*sky.Property) []string {
+	byName := map[string]*sky.Property{}
+	for _, p := range existing {
+		byName[p.Name] = p
+	}
+
+	var drift []string
+	for _, property := range expected {
+		want := fmt.Sprintf("%s (transient: %v)", property.DataType, property.Transient)
+		current := byName[property.Name]
+		if current == nil {
+			drift = append(drift, fmt.Sprintf("%s: expected %s, missing from table", property.Name, want))
+		} else if !strings.EqualFold(current.DataType, property.DataType) || current.Transient != property.Transient {
+			drift = append(drift, fmt.Sprintf("%s: expected %s, found %s (transient: %v)", property.Name, want, current.DataType, current.Transient))
+		}
+	}
+	return drift
+}
+
+// Returns the properties that are created on a new table.
+func tableProperties() []*sky.Property {
+	return TableProperties(config.Schema)
+}
+
+// Returns the record paths that are read into events.
+func mappedPaths() []string {
+	paths := []string{"created_at", "actor"}
+	for _, p := range config.Schema {
+		paths = append(paths, p.Paths()...)
+	}
+	return paths
+}
+
+//--------------------------------------
+// Import
+//--------------------------------------
+
+// Imports a list of hours using a pool of -concurrency workers, each of
+// which downloads and parses one hour at a time. Events from every worker
+// go to the same streamer. Returns the number of hours that completed, the
+// number that failed and the error that aborted the run, if any. Hours cut
+// short by cancelling the context are neither completed nor failed.
+func importHours(ctx context.Context, s *streamer, guard *factorGuard, merger *orderedMerger, manifest *manifestWriter, dates []time.Time) (int, int, error) {
+	var wg sync.WaitGroup
+	tally := &hourTally{}
+
+	work := make(chan time.Time)
+	for i := 0; i < config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for date := range work {
+				tally.record(ctx, importHour(ctx, s, guard, merger, manifest, date))
+			}
+		}()
+	}
+
+	for i, date := range dates {
+		// Stop between hours so no hour is left partially imported.
+		if stopRequested() {
+			warn("Stopping: %s. %d of %d hours started.", stopReason, i, len(dates))
+			for _, date := range dates[i:] {
+				recordHour(manifest, date, 0, hourSkipped, nil)
+			}
+			if manifest != nil {
+				info("Resume with -retry-manifest %s.", config.ManifestPath)
+			} else {
+				info("Resume from %s.", date.Format(time.RFC3339))
+			}
+			break
+		}
+		reorder.wait(i)
+		work <- date
+	}
+	close(work)
+	wg.Wait()
+
+	return tally.completed, tally.failed, tally.abortErr
+}
+
+// Imports records from a reader, such as standard input piped from zcat or
+// jq, in place of a range of hours. The records aren't tied to an hour so
+// their timestamps are used as they are.
+func importInput(ctx context.Context, s *streamer, guard *factorGuard, merger *orderedMerger, r io.Reader) error {
+	count, err := importRecords(ctx, s, guard, merger, r, time.Time{})
+	if err == errLimitReached || ctx.Err() != nil {
+		err = nil
+	}
+	if err != nil {
+		logFields{"error": err}.warn("Unable to read input after %d events.", count)
+	}
+	return err
+}
+
+// Counts the outcome of an hour. A fatal error stops the run, as does any
+// failure with -continue-on-error=false.
+func (t *hourTally) record(ctx context.Context, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err == nil || err == errArchiveNotFound {
+		t.completed++
+	} else if ctx.Err() == nil && err != errLimitReached && err != errHourTimedOut {
+		t.failed++
+		stats.hourFailed()
+		if !config.ContinueOnError {
+			requestStop("an hour failed")
+		}
+	}
+	if (err == errFactorOverflow || err == errUnmappedField || err == errConnectionLost || err == errSkipRateExceeded) && t.abortErr == nil {
+		t.abortErr = err
+		requestStop("import aborted")
+	}
+}
+
+// Records the outcome of an hour in the manifest and, unless it was left
+// out by -limit, in the run stats.
+func recordHour(manifest *manifestWriter, date time.Time, count int, status string, err error) {
+	if err != errLimitReached {
+		stats.hourOutcome(date, status)
+	}
+	manifest.write(date, count, status, err)
+}
+
+// Imports a single hour and records its outcome in the manifest.
+func importHour(ctx context.Context, s *streamer, guard *factorGuard, merger *orderedMerger, manifest *manifestWriter, date time.Time) error {
+	return runHour(ctx, merger, manifest, date, func(ctx context.Context) (int, error) {
+		return importDate(ctx, s, guard, merger, manifest, date)
+	})
+}
+
+// Runs the import of an hour, bounded by -hour-timeout, and records its
+// outcome in the manifest, the stats and the checkpoint. The import
+// returns the number of events read.
+func runHour(ctx context.Context, merger *orderedMerger, manifest *manifestWriter, date time.Time, importFn func(context.Context) (int, error)) error {
+	stats.startHour(date)
+	defer stats.finishHour(date)
+
+	// Bound the time spent on the hour without stopping the run.
+	hourCtx := ctx
+	if config.HourTimeout > 0 {
+		var cancel context.CancelFunc
+		hourCtx, cancel = context.WithTimeout(ctx, config.HourTimeout)
+		defer cancel()
+	}
+
+	fields := logFields{"hour": date.Format(time.RFC3339), "url": archiveURL(date)}
+	count, err := importFn(hourCtx)
+	if err == nil && connectionLost() {
+		err = errConnectionLost
+	}
+	if err != nil && ctx.Err() == nil && hourCtx.Err() == context.DeadlineExceeded {
+		err = errHourTimedOut
+	}
+	if err == errFactorOverflow || err == errUnmappedField || err == errConnectionLost || err == errSkipRateExceeded {
+		recordHour(manifest, date, count, hourFailed, err)
+	} else if err == errHourTimedOut {
+		fields.warn("Timed out after %v and %d events, skipping.", config.HourTimeout, count)
+		stats.timedOut()
+		recordHour(manifest, date, count, hourSkipped, err)
+		if config.RetryListPath != "" {
+			if err := appendRetryList(config.RetryListPath, date); err != nil {
+				warn("Unable to write retry list: %v", err)
+			}
+		}
+	} else if err != nil && ctx.Err() != nil {
+		fields.warn("Interrupted after %d events.", count)
+		recordHour(manifest, date, count, hourFailed, err)
+	} else if err == errArchiveNotFound {
+		fields.warn("Archive not available.")
+		recordHour(manifest, date, count, hourSkipped, err)
+	} else if err == errLimitReached {
+		recordHour(manifest, date, count, hourSkipped, err)
+	} else if err != nil {
+		fields["error"] = err
+		fields.warn("Invalid file.")
+		recordHour(manifest, date, count, hourFailed, err)
+	} else {
+		recordHour(manifest, date, count, hourOK, nil)
+	}
+
+	// Commit everything that can no longer be preceded by a later file.
+	if merger != nil {
+		merger.flush(date.Add(time.Hour - time.Duration(config.GlobalOrderWindow)*time.Hour))
+	}
+
+	// Every event from the hour has been written so it won't be imported
+	// again on resume. Reordered hours are written once every earlier
+	// hour has been.
+	reorder.finish(date)
+	if (err == nil || err == errArchiveNotFound) && !connectionLost() {
+		checkpoint.complete(date)
+		lineProgress.complete(date)
+	}
+	return err
+}
+
+// Imports GitHub Archive data for a given hour. Returns the number of
+// events read from the file.
+//
+// A downloaded file that turns out to be truncated is fetched and imported
+// again, with the same backoff as a failed download. Events already added
+// from the partial file are added again at the same timestamps, so they
+// replace rather than duplicate the earlier ones.
+func importDate(ctx context.Context, s *streamer, guard *factorGuard, merger *orderedMerger, manifest *manifestWriter, date time.Time) (int, error) {
+	for attempt := 0; ; attempt++ {
+		count, err := importArchive(ctx, s, guard, merger, manifest, date)
+		if !isTruncated(err) || readingLocalFiles() || attempt >= config.Retries || ctx.Err() != nil {
+			return count, err
+		}
+
+		// Don't read the truncated copy from the cache again.
+		if config.CacheDir != "" && !config.NoCacheWrite {
+			os.Remove(filepath.Join(config.CacheDir, archiveName(date)))
+		}
+
+		delay := retryDelay(attempt)
+		logFields{"url": archiveURL(date), "error": err}.warn("Truncated after %d events, retrying in %v (%d/%d).", count, delay, attempt+1, config.Retries)
+		if !sleepContext(ctx, delay) {
+			return count, err
+		}
+	}
+}
+
+// Makes a single attempt at importing an hour's archive. Once the whole
+// file has been imported its checksum is recorded in the manifest.
+func importArchive(ctx context.Context, s *streamer, guard *factorGuard, merger *orderedMerger, manifest *manifestWriter, date time.Time) (int, error) {
+	// Retrieve and decompress the JSON file.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	archive, err := openArchive(ctx, date)
+	if err != nil {
+		return 0, err
+	}
+
+	// The decoder may still be reading when the import stops early, so
+	// cancel the download to end that read and wait for it before closing
+	// the archive.
+	reader := &stoppableReader{r: archive}
+	defer func() {
+		cancel()
+		reader.stop()
+		archive.Close()
+	}()
+
+	count, err := importRecords(ctx, s, guard, merger, reader, date)
+	if r, ok := archive.(*archiveReader); ok && err == nil && manifest != nil {
+		var sum string
+		var size int64
+		if sum, size, err = r.checksum(); err == nil {
+			manifest.digest(date, sum, size)
+		}
+	}
+	if isTimeout(err) {
+		err = fmt.Errorf("Timed out reading %s after %v: %w", archiveURL(date), config.HTTPTimeout, err)
+	} else if isTruncated(err) {
+		err = fmt.Errorf("Truncated archive %s: %w", archiveURL(date), err)
+	}
+	return count, err
+}
+
+// Imports newline-delimited archive records from a reader. The date is the
+// hour the records were published in, if known. Returns the number of
+// events read once every event added directly has been written. Reading
+// stops if the context is cancelled.
+func importRecords(ctx context.Context, s *streamer, guard *factorGuard, merger *orderedMerger, reader io.Reader, date time.Time) (int, error) {
+	d := newRecordDecoder(reader, config.ParseWorkers)
+	defer d.close()
+
+	var pending sync.WaitGroup
+	defer pending.Wait()
+
+	// Skip the lines an earlier attempt wrote.
+	skipLines := lineProgress.start(date)
+	if skipLines > 0 {
+		logFields{"hour": date.Format(time.RFC3339)}.info("Resuming after line %d.", skipLines)
+	}
+
+	count := 0
+	queued := 0
+	read, invalid := 0, 0
+	var events userEvents
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		} else if limitReached() {
+			return count, errLimitReached
+		} else if connectionLost() {
+			return count, errConnectionLost
+		} else if skipRateExceeded(date, read, invalid) {
+			return count, errSkipRateExceeded
+		}
+		record, err := d.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return count, err
+		}
+		lineNumber := record.lineNumber
+		if lineNumber <= skipLines {
+			continue
+		}
+		stats.read(date)
+		read++
+
+		// Parse data from the stream.
+		data := record.data
+		if record.err != nil {
+			invalid++
+			lineFields(date, lineNumber, record.err).warn("Invalid JSON.")
+			stats.skipped(date, "invalid JSON")
+			rejects.write(record.line, date, "invalid JSON: "+record.err.Error())
+			continue
+		}
+
+		// Skip event types that weren't asked for.
+		if allowedTypes != nil {
+			if eventType, _ := data["type"].(string); !allowedTypes[eventType] {
+				stats.skipped(date, "filtered type")
+				continue
+			}
+		}
+
+		// Skip repositories that weren't asked for.
+		if allowedRepos != nil {
+			if name, _ := RepoName(data); !allowedRepos[strings.ToLower(name)] {
+				stats.skipped(date, "filtered repo")
+				continue
+			}
+		}
+
+		// Create an event.
+		username, event, err := parseEvent(data, date)
+		if err != nil && err != errFilteredUser {
+			invalid++
+		}
+		switch {
+		case err == errFilteredUser:
+			stats.skipped(date, "filtered user")
+			continue
+		case err == ErrNoTimestamp:
+			stats.skipped(date, "no timestamp")
+			rejects.write(record.line, date, "no timestamp")
+			lineFields(date, lineNumber, nil).debug("Timestamp required.")
+			continue
+		case err == ErrNoActor:
+			stats.skipped(date, "no actor")
+			rejects.write(record.line, date, "no actor")
+			lineFields(date, lineNumber, nil).debug("Actor required (expected a login or an actor object).")
+			continue
+		case err != nil:
+			stats.skipped(date, "bad timestamp")
+			rejects.write(record.line, date, "bad timestamp: "+err.Error())
+			lineFields(date, lineNumber, err).debug("Invalid timestamp.")
+			continue
+		}
+
+		// Account for every field in strict mode.
+		if err := strict.check(data, lineNumber); err != nil {
+			return count, err
+		}
+
+		// Drop incomplete events.
+		if field := missingField(event); field != "" {
+			stats.missingField(date, field)
+			invalid++
+			continue
+		}
+
+		// Skip events that have already been imported.
+		if eventType, _ := data["type"].(string); dedupe.testAndAdd(username, event.Timestamp, eventType) {
+			stats.skipped(date, "duplicate")
+			continue
+		}
+
+		// Skip users that have already been seen.
+		if seenUsers != nil && seenUsers.testAndAdd(username) {
+			stats.skipped(date, "seen user")
+			continue
+		}
+
+		if err := guard.check(event); err != nil {
+			return count, err
+		}
+		count++
+		if config.LogSample > 0 && atomic.AddInt64(&sampleCount, 1)%int64(config.LogSample) == 0 {
+			logSampleEvent(username, event)
+		}
+
+		// Tally the event, leaving it out of the import if only the
+		// tallies are wanted.
+		if actionTallies != nil {
+			eventType, _ := data["type"].(string)
+			actionTallies.add(username, eventType, event.Timestamp)
+			if config.CountActionsOnly {
+				continue
+			}
+		}
+
+		// Hold events for ordered, reordered, windowed or sorted commits,
+		// otherwise add them now, waiting for each batch to be written
+		// before reading more.
+		e := &userEvent{username: username, event: event, hour: date}
+		if rejects != nil {
+			e.line = record.line
+		}
+		if merger != nil {
+			merger.push(e)
+		} else if reorder != nil {
+			reorder.push(e)
+		} else if config.TimeWindow > 0 || config.Presort {
+			events = append(events, e)
+		} else {
+			s.add(e, &pending)
+			if queued++; config.BatchSize > 0 && queued%config.BatchSize == 0 {
+				pending.Wait()
+				lineProgress.record(date, lineNumber)
+			}
+		}
+
+		// Write what's held early if memory is running out, at the cost
+		// of ordering within the hour.
+		if count%memoryCheckInterval == 0 && memory.underPressure() {
+			commitHeld(s, events)
+			events = nil
+			pending.Wait()
+			memory.relieve()
+		}
+	}
+
+	commitHeld(s, events)
+
+	if skipRateExceeded(date, read, invalid) {
+		return count, errSkipRateExceeded
+	}
+	return count, nil
+}
+
+// Returns true if more than -max-skip-rate of the records read from an
+// hour were invalid or incomplete, once enough have been read to judge.
+// Records left out by a filter or as duplicates don't count against the
+// rate. Logs why the import is being aborted.
+func skipRateExceeded(date time.Time, read int, invalid int) bool {
+	if config.MaxSkipRate <= 0 || read < minSkipRateSample || float64(invalid) <= config.MaxSkipRate*float64(read) {
+		return false
+	}
+	fields := logFields{"read": read, "invalid": invalid}
+	if !date.IsZero() {
+		fields["url"] = archiveURL(date)
+	}
+	fields.error("%d of %d records couldn't be imported, more than -max-skip-rate %g. The archive likely doesn't match the expected schema; check a sample with -dump-unmapped.", invalid, read, config.MaxSkipRate)
+	return true
+}
+
+// Logs an event as it will be added, with every property it has a value
+// for, as a heartbeat of what's being imported.
+func logSampleEvent(username string, event *sky.Event) {
+	fields := logFields{"username": username, "timestamp": event.Timestamp.Format(time.RFC3339)}
+	for name, value := range event.Data {
+		fields[name] = value
+	}
+	fields.info("Sample event.")
+}
+
+// Writes the events held by importRecords for windowed or sorted commits.
+func commitHeld(s *streamer, events userEvents) {
+	if config.TimeWindow > 0 {
+		commitWindows(s, events, config.TimeWindow)
+	} else if config.Presort {
+		sort.Stable(events)
+		commitBatches(s, events, config.BatchSize)
+	}
+}
+
+// Returns the log fields for a line of an hour's file.
+func lineFields(date time.Time, lineNumber int, err error) logFields {
+	fields := logFields{"line": lineNumber}
+	if !date.IsZero() {
+		fields["url"] = archiveURL(date)
+	}
+	if err != nil {
+		fields["error"] = err
+	}
+	return fields
+}
+
+// Moves a timestamp outside of an hour to the first or last second of that
+// hour so hour-partitioned tables stay clean.
+func clampTimestamp(timestamp time.Time, hour time.Time) time.Time {
+	if timestamp.Before(hour) {
+		stats.clamped()
+		return hour
+	} else if end := hour.Add(time.Hour - time.Second); timestamp.After(end) {
+		stats.clamped()
+		return end
+	}
+	return timestamp
+}
+
+// Opens a file for event output, or a directory of files when partitioning.
+// A path of "-" writes to stdout.
+func newOutput(path string) (eventOutput, error) {
+	if path == "-" && config.PartitionBy == "" {
+		w := newEventFileWriter(os.Stdout)
+		w.canonical = config.CanonicalJSON
+		return w, nil
+	} else if config.PartitionBy != "" {
+		w, err := newPartitionWriter(path, config.PartitionBy, config.MaxOpenPartitions)
+		if err != nil {
+			return nil, err
+		}
+		w.canonical = config.CanonicalJSON
+		return w, nil
+	}
+
+	w, err := newEventWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	w.canonical = config.CanonicalJSON
+	return w, nil
+}
+
+// Turns a decoded archive record into the user and event to import. The
+// timestamp is converted to UTC and truncated to the -truncate resolution.
+// The date is the hour the record was published in, if known, and is used
+// to clamp the timestamp with -clamp-to-hour. Returns ErrNoTimestamp or
+// ErrNoActor if the record is missing either, the parse error if its
+// timestamp is invalid, and errFilteredUser if the user wasn't selected
+// with -users.
+func parseEvent(data map[string]interface{}, date time.Time) (string, *sky.Event, error) {
+	username, timestamp, err := ParseRecord(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Skip users that weren't asked for before building the event.
+	if allowedUsers != nil && !allowedUsers[strings.ToLower(username)] {
+		return username, nil, errFilteredUser
+	}
+
+	if config.ClampToHour && !date.IsZero() {
+		timestamp = clampTimestamp(timestamp, date)
+	}
+	if config.Truncate > 0 {
+		timestamp = timestamp.Truncate(config.Truncate)
+	}
+	return username, newEvent(data, timestamp), nil
+}
+
+// Builds an event from a record's schema properties, such as the action
+// from the record's type. Values that are missing or of the wrong type are
+// left off the event. With -compact-data, factor values are interned.
+func newEvent(data map[string]interface{}, timestamp time.Time) *sky.Event {
+	event := NewEvent(config.Schema, data, timestamp)
+	if interner != nil {
+		for _, property := range config.Schema {
+			if str, isString := event.Data[property.Name].(string); isString && property.DataType() == sky.Factor {
+				event.Data[property.Name] = interner.intern(str)
+			}
+		}
+	}
+	return event
+}
+
+// Returns the first required property an event doesn't have a value for,
+// or a blank string if it has them all.
+func missingField(event *sky.Event) string {
+	for _, name := range config.RequiredFields {
+		if value, ok := event.Data[name]; !ok || value == nil || value == "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// Checks that each required property is a property of the table.
+func checkRequiredFields(schema []*Property, names []string) error {
+	properties := map[string]bool{}
+	for _, property := range TableProperties(schema) {
+		properties[property.Name] = true
+	}
+	for _, name := range names {
+		if !properties[name] {
+			return fmt.Errorf("Unknown required field: %s", name)
+		}
+	}
+	return nil
+}
+
+// Returns the names as a set, lowercased if they're matched
+// case-insensitively as GitHub does for users and repositories. Returns nil
+// if there are no names so that everything is imported.
+func nameSet(names []string, lower bool) map[string]bool {
+	var set map[string]bool
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		} else if lower {
+			name = strings.ToLower(name)
+		}
+		if set == nil {
+			set = map[string]bool{}
+		}
+		set[name] = true
+	}
+	return set
+}
@@ -0,0 +1,273 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/skydb/sky.go"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Ensures that the action is set from the record's type.
+func TestNewEventAction(t *testing.T) {
+	data := mustDecode(t, `{"type":"PushEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00Z","repository":{"language":"Go"}}`)
+	event := newEvent(data, time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC))
+	if action := event.Data["action"]; action != "PushEvent" {
+		t.Fatalf("Unexpected action: %v", action)
+	}
+	if language := event.Data["language"]; language != "Go" {
+		t.Fatalf("Unexpected language: %v", language)
+	}
+}
+
+// Ensures that archive records are turned into events or skipped.
+func TestParseEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		username string
+		data     map[string]interface{}
+		err      error
+	}{
+		{
+			name:     "pre-2015 record",
+			line:     `{"type":"WatchEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00-08:00","repository":{"language":"Go","forks":3,"watchers":10,"size":1024}}`,
+			username: "benbjohnson",
+			data:     map[string]interface{}{"action": "WatchEvent", "language": "Go", "forks": 3, "watchers": 10, "size": 1024},
+		},
+		{
+			name:     "post-2015 record",
+			line:     `{"type":"PushEvent","actor":{"id":1,"login":"benbjohnson"},"created_at":"2015-01-01T15:00:00Z","payload":{"size":2,"distinct_size":1}}`,
+			username: "benbjohnson",
+			data:     map[string]interface{}{"action": "PushEvent", "commits": 1},
+		},
+		{
+			name: "missing actor",
+			line: `{"type":"PushEvent","created_at":"2013-01-01T00:00:00Z"}`,
+			err:  ErrNoActor,
+		},
+		{
+			name: "missing timestamp",
+			line: `{"type":"PushEvent","actor":"benbjohnson"}`,
+			err:  ErrNoTimestamp,
+		},
+		{
+			name:     "null language",
+			line:     `{"type":"ForkEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00Z","repository":{"language":null,"size":10}}`,
+			username: "benbjohnson",
+			data:     map[string]interface{}{"action": "ForkEvent", "size": 10},
+		},
+		{
+			name:     "missing repository",
+			line:     `{"type":"FollowEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00Z"}`,
+			username: "benbjohnson",
+			data:     map[string]interface{}{"action": "FollowEvent"},
+		},
+		{
+			name:     "null repository",
+			line:     `{"type":"FollowEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00Z","repository":null}`,
+			username: "benbjohnson",
+			data:     map[string]interface{}{"action": "FollowEvent"},
+		},
+		{
+			name:     "empty language",
+			line:     `{"type":"ForkEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00Z","repository":{"language":"","size":10}}`,
+			username: "benbjohnson",
+			data:     map[string]interface{}{"action": "ForkEvent", "size": 10},
+		},
+		{
+			name:     "non-string language",
+			line:     `{"type":"ForkEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00Z","repository":{"language":{"name":"Go"}}}`,
+			username: "benbjohnson",
+			data:     map[string]interface{}{"action": "ForkEvent"},
+		},
+		{
+			name:     "numeric language",
+			line:     `{"type":"ForkEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00Z","repository":{"language":42}}`,
+			username: "benbjohnson",
+			data:     map[string]interface{}{"action": "ForkEvent", "language": float64(42)},
+		},
+		{
+			name:     "non-numeric size",
+			line:     `{"type":"ForkEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00Z","repository":{"language":"Go","size":"large"}}`,
+			username: "benbjohnson",
+			data:     map[string]interface{}{"action": "ForkEvent", "language": "Go"},
+		},
+	}
+
+	for _, tt := range tests {
+		username, event, err := parseEvent(mustDecode(t, tt.line), time.Time{})
+		if err != tt.err {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if username != tt.username {
+			t.Errorf("%s: unexpected username: %s", tt.name, username)
+		}
+		if len(event.Data) != len(tt.data) {
+			t.Errorf("%s: unexpected data: %v", tt.name, event.Data)
+		}
+		for k, v := range event.Data {
+			if v == nil || v == "" {
+				t.Errorf("%s: unexpected blank %s", tt.name, k)
+			}
+		}
+		for k, v := range tt.data {
+			if event.Data[k] != v {
+				t.Errorf("%s: unexpected %s: %v (%T)", tt.name, k, event.Data[k], event.Data[k])
+			}
+		}
+	}
+}
+
+// Ensures that an invalid timestamp is reported with the parse error.
+func TestParseEventBadTimestamp(t *testing.T) {
+	_, _, err := parseEvent(mustDecode(t, `{"type":"PushEvent","actor":"benbjohnson","created_at":"yesterday"}`), time.Time{})
+	if err == nil || err == ErrNoTimestamp {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// Ensures that timestamps are converted to UTC.
+func TestParseEventTimestamp(t *testing.T) {
+	_, event, err := parseEvent(mustDecode(t, `{"type":"PushEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00-08:00"}`), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Timestamp != time.Date(2013, 1, 1, 8, 0, 0, 0, time.UTC) {
+		t.Fatalf("Unexpected timestamp: %v", event.Timestamp)
+	}
+}
+
+// Ensures that timestamps are truncated to the -truncate resolution.
+func TestParseEventTruncate(t *testing.T) {
+	config.Truncate = time.Minute
+	defer func() { config.Truncate = 0 }()
+
+	_, event, err := parseEvent(mustDecode(t, `{"type":"PushEvent","actor":"benbjohnson","created_at":"2013-01-01T00:05:42.250-08:00"}`), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Timestamp != time.Date(2013, 1, 1, 8, 5, 0, 0, time.UTC) {
+		t.Fatalf("Unexpected timestamp: %v", event.Timestamp)
+	}
+}
+
+// Ensures that the repository is found in both archive formats.
+func TestRepoName(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+	}{
+		{`{"repo":{"id":1,"name":"rails/rails"}}`, "rails/rails"},
+		{`{"repository":{"owner":"rails","name":"rails","url":"https://github.com/rails/rails"}}`, "rails/rails"},
+		{`{"repository":{"url":"https://github.com/rails/rails"}}`, "rails/rails"},
+		{`{"type":"FollowEvent"}`, ""},
+	}
+	for _, tt := range tests {
+		if name, _ := RepoName(mustDecode(t, tt.line)); name != tt.name {
+			t.Errorf("%s: unexpected repository: %q", tt.line, name)
+		}
+	}
+}
+
+// Decodes an archive line the way the importer does.
+func mustDecode(t *testing.T, line string) map[string]interface{} {
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Ensures that flattened payload fields are only set for their event types.
+func TestFlattenPayload(t *testing.T) {
+	defer func(s []*Property) { config.Schema = s }(config.Schema)
+	var err error
+	if config.Schema, err = ExtendSchema(DefaultSchema(), PayloadSchema()); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		line string
+		data map[string]interface{}
+	}{
+		{`{"type":"PullRequestEvent","payload":{"action":"closed","pull_request":{"merged":true}}}`, map[string]interface{}{"payload_action": "closed", "payload_merged": true}},
+		{`{"type":"CreateEvent","payload":{"ref_type":"tag","action":"ignored"}}`, map[string]interface{}{"payload_ref_type": "tag"}},
+		{`{"type":"ForkEvent","payload":{"action":"unknown"}}`, map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		event := newEvent(mustDecode(t, tt.line), timestamp)
+		for _, p := range PayloadSchema() {
+			if value := event.Data[p.Name]; value != tt.data[p.Name] {
+				t.Errorf("%s: unexpected %s: %v", tt.line, p.Name, value)
+			}
+		}
+	}
+
+	if _, err := ExtendSchema(PayloadSchema(), PayloadSchema()); err == nil {
+		t.Fatal("Expected an error for duplicate properties.")
+	}
+}
+
+// Ensures that missing and mismatched table properties are described.
+func TestSchemaDrift(t *testing.T) {
+	expected := []*sky.Property{
+		sky.NewProperty("username", false, sky.String),
+		sky.NewProperty("action", true, sky.Factor),
+		sky.NewProperty("language", true, sky.Factor),
+		sky.NewProperty("size", true, sky.Integer),
+		sky.NewProperty("forks", true, sky.Integer),
+	}
+	existing := []*sky.Property{
+		sky.NewProperty("username", false, sky.String),
+		sky.NewProperty("action", true, "FACTOR"),
+		sky.NewProperty("language", true, sky.Integer),
+		sky.NewProperty("size", false, sky.Integer),
+		sky.NewProperty("stale", true, sky.String),
+	}
+	drift := schemaDrift(expected, existing)
+	want := []string{
+		"language: expected factor (transient: true), found integer (transient: true)",
+		"size: expected integer (transient: true), found integer (transient: false)",
+		"forks: expected integer (transient: true), missing from table",
+	}
+	if !reflect.DeepEqual(drift, want) {
+		t.Fatalf("Unexpected drift:\n%s", strings.Join(drift, "\n"))
+	}
+	if drift := schemaDrift(expected, expected); len(drift) != 0 {
+		t.Fatalf("Unexpected drift: %v", drift)
+	}
+}
+
+// Ensures that an hour aborts once too many of its records are invalid,
+// and that filtered records don't count against the rate.
+func TestMaxSkipRate(t *testing.T) {
+	config.MaxSkipRate, allowedTypes = 0.5, map[string]bool{"PushEvent": true}
+	defer func() { config.MaxSkipRate, allowedTypes = 0, nil }()
+
+	invalid := `{"type":"PushEvent","created_at":"2013-01-01T00:00:00Z"}` + "\n"
+	filtered := `{"type":"WatchEvent","actor":"benbjohnson","created_at":"2013-01-01T00:00:00Z"}` + "\n"
+	tests := []struct {
+		name  string
+		lines string
+		err   error
+	}{
+		{"all invalid", strings.Repeat(invalid, 1500), errSkipRateExceeded},
+		{"mostly invalid at the end", strings.Repeat(filtered, 600) + strings.Repeat(invalid, 900), errSkipRateExceeded},
+		{"under the rate", strings.Repeat(filtered+invalid, 750), nil},
+		{"mostly filtered", strings.Repeat(filtered, 1500), nil},
+		{"under the sample size", strings.Repeat(invalid, minSkipRateSample-1), nil},
+	}
+	for _, tt := range tests {
+		_, err := importRecords(context.Background(), nil, nil, nil, strings.NewReader(tt.lines), time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC))
+		if err != tt.err {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,890 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/skydb/sky.go"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+const (
+	defaultHost                = "localhost"
+	defaultPort                = 8585
+	defaultTableName           = "gharchive"
+	defaultFactorOverflow      = "abort"
+	defaultProgressInterval    = 5 * time.Second
+	defaultGlobalOrderWindow   = 1
+	defaultFactorLengthMode    = "truncate"
+	defaultStreamWorkers       = 1
+	defaultMaxRedirects        = 10
+	defaultStripAuthOnRedirect = true
+	defaultBloomCapacity       = 10000000
+	defaultBloomFPRate         = 0.001
+	defaultArchiveExt          = ".json.gz"
+	defaultMaxOpenPartitions   = 64
+	defaultFactorNumberFormat  = "plain"
+	defaultParseWorkers        = 1
+	defaultRetries             = 3
+	defaultRetryBaseDelay      = 1 * time.Second
+	defaultConcurrency         = 1
+	defaultBatchSize           = 1000
+	defaultHTTPTimeout         = 60 * time.Second
+	defaultBaseURL             = "https://data.gharchive.org"
+	defaultStreamBuffer        = 1000
+	defaultStep                = time.Hour
+	defaultContinueOnError     = true
+	defaultReconnectRetries    = 3
+	defaultReconnectDelay      = 1 * time.Second
+	defaultCountActionsOutput  = "sky"
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// Config holds the options of an import. Each field is the option of the
+// sky-gharchive-importer command with the same name, such as Concurrency
+// for -concurrency, and DefaultConfig returns the command's defaults.
+type Config struct {
+	// The Sky server and the table events are added to.
+	Host      string
+	Port      int
+	TableName string
+	Overwrite bool
+	Preflight bool
+
+	// The properties read from each record. Nil is DefaultSchema.
+	Schema []*Property
+
+	// Where events go instead of Sky, or as well as Sky.
+	DryRun            bool
+	OutputPath        string
+	PartitionBy       string
+	MaxOpenPartitions int
+	CanonicalJSON     bool
+	DualWrite         string
+	DualWriteValidate bool
+
+	// Where records are read from. By default each hour is downloaded from
+	// BaseURL. Input, when set, is read in place of any hours.
+	BaseURL       string
+	ArchiveExt    string
+	SourceDir     string
+	BundlePath    string
+	LocalFiles    map[time.Time]string
+	Input         io.Reader
+	ReplayRejects string
+
+	// Downloading.
+	CacheDir            string
+	NoCacheWrite        bool
+	CacheRevalidate     bool
+	VerifyDownload      bool
+	ChecksumsPath       string
+	HTTPTimeout         time.Duration
+	Retries             int
+	RetryBaseDelay      time.Duration
+	MaxRPS              float64
+	MaxBandwidth        string
+	MaxRedirects        int
+	StripAuthOnRedirect bool
+	RequestHeaders      http.Header
+	Proxy               *url.URL
+	MaxIdleConns        int
+	IdleConnTimeout     time.Duration
+
+	// The pipeline and the order events are added in.
+	Concurrency       int
+	ParseWorkers      int
+	StreamWorkers     int
+	StreamBuffer      int
+	BatchSize         int
+	Presort           bool
+	TimeWindow        time.Duration
+	GlobalOrder       bool
+	GlobalOrderWindow int
+	ReorderWindow     int
+	Step              time.Duration
+	HourTimeout       time.Duration
+	MaxRuntime        time.Duration
+	MaxMemory         string
+	CompactData       bool
+
+	// The records and events that are imported.
+	EventTypes     []string
+	Users          []string
+	Repos          []string
+	RequiredFields []string
+	OnlyNewUsers   bool
+	BloomCapacity  int
+	BloomFPRate    float64
+	DedupeWindow   int
+	ClampToHour    bool
+	Truncate       time.Duration
+	EventLimit     int
+
+	// Checks on the values imported.
+	MaxFactorValues    int
+	FactorOverflow     string
+	MaxFactorLength    int
+	FactorLengthMode   string
+	FactorNumberFormat string
+	StrictSchema       string
+	StrictFields       []string
+	MaxSkipRate        float64
+
+	// Failures.
+	ContinueOnError     bool
+	ReconnectRetries    int
+	ReconnectDelay      time.Duration
+	SkipPingOnReconnect bool
+
+	// Files recording the progress and outcome of the run.
+	CheckpointPath string
+	Restart        bool
+	ResumeLines    bool
+	ManifestPath   string
+	RejectsPath    string
+	RetryListPath  string
+
+	// Per-user tallies of each event type.
+	CountActions       bool
+	CountActionsOnly   bool
+	CountActionsOutput string
+
+	// Reporting. Dates are logged in DateLocation as well as UTC.
+	LogSample        int
+	StatsInterval    time.Duration
+	ShowProgress     bool
+	ProgressSocket   string
+	ProgressInterval time.Duration
+	MetricsAddr      string
+	DateLocation     *time.Location
+}
+
+// Stats is the outcome of a run.
+type Stats struct {
+	StartTime      time.Time
+	EndTime        time.Time
+	HoursTotal     int
+	HoursCompleted int
+	HoursFailed    int
+	HoursSkipped   int
+	FailedHours    []time.Time
+	SkippedHours   []time.Time
+	EventsRead     int
+	EventsAdded    int
+	EventsSkipped  int
+	SkipReasons    map[string]int
+
+	// True if an hour failed or was skipped, or the events written to
+	// DualWrite didn't match the ones added to Sky.
+	Incomplete bool
+}
+
+// Importer imports GitHub Archive hours into a Sky table. The pipeline
+// keeps its state in the package, so only one Importer runs at a time;
+// the methods of others wait for it to finish.
+type Importer struct {
+	config      Config
+	checksums   map[string]string
+	bandwidth   uint64
+	memoryLimit uint64
+}
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// The options of the current run.
+var config = DefaultConfig()
+
+// Held for the whole of a run.
+var runMutex sync.Mutex
+
+// The state of the current run, created from its options.
+var dualWriter eventOutput
+var dualCounts *dualWriteCounts
+var seenUsers *bloomFilter
+var strict *strictChecker
+var checkpoint *checkpointWriter
+var allowedTypes map[string]bool
+var allowedUsers map[string]bool
+var allowedRepos map[string]bool
+var rejects *rejectsWriter
+var dedupe *dedupeSet
+var output eventOutput
+var reorder *reorderBuffer
+var sampleCount int64
+var memory *memoryGuard
+var actionTallies *actionCounter
+var lineProgress *lineCheckpoint
+var interner *stringInterner
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// DefaultConfig returns the options the command runs with when none are
+// given.
+func DefaultConfig() Config {
+	return Config{
+		Host:                defaultHost,
+		Port:                defaultPort,
+		TableName:           defaultTableName,
+		Schema:              DefaultSchema(),
+		MaxOpenPartitions:   defaultMaxOpenPartitions,
+		BaseURL:             defaultBaseURL,
+		ArchiveExt:          defaultArchiveExt,
+		HTTPTimeout:         defaultHTTPTimeout,
+		Retries:             defaultRetries,
+		RetryBaseDelay:      defaultRetryBaseDelay,
+		MaxRedirects:        defaultMaxRedirects,
+		StripAuthOnRedirect: defaultStripAuthOnRedirect,
+		RequestHeaders:      http.Header{},
+		IdleConnTimeout:     defaultIdleConnTimeout,
+		Concurrency:         defaultConcurrency,
+		ParseWorkers:        defaultParseWorkers,
+		StreamWorkers:       defaultStreamWorkers,
+		StreamBuffer:        defaultStreamBuffer,
+		BatchSize:           defaultBatchSize,
+		GlobalOrderWindow:   defaultGlobalOrderWindow,
+		Step:                defaultStep,
+		BloomCapacity:       defaultBloomCapacity,
+		BloomFPRate:         defaultBloomFPRate,
+		FactorOverflow:      defaultFactorOverflow,
+		FactorLengthMode:    defaultFactorLengthMode,
+		FactorNumberFormat:  defaultFactorNumberFormat,
+		ContinueOnError:     defaultContinueOnError,
+		ReconnectRetries:    defaultReconnectRetries,
+		ReconnectDelay:      defaultReconnectDelay,
+		CountActionsOutput:  defaultCountActionsOutput,
+		ProgressInterval:    defaultProgressInterval,
+		DateLocation:        time.UTC,
+	}
+}
+
+// New checks the options of an import and reads the files they name, such
+// as -checksums, returning the first problem found.
+func New(config Config) (*Importer, error) {
+	var err error
+	i := &Importer{config: config}
+	c := &i.config
+	if c.Schema == nil {
+		c.Schema = DefaultSchema()
+	}
+	if c.DateLocation == nil {
+		c.DateLocation = time.UTC
+	}
+
+	if err = validateBaseURL(c.BaseURL); err != nil {
+		return nil, err
+	}
+	if c.MaxRPS < 0 {
+		return nil, fmt.Errorf("Invalid request rate: %v", c.MaxRPS)
+	}
+	if c.MaxBandwidth != "" {
+		if i.bandwidth, err = parseBandwidth(c.MaxBandwidth); err != nil {
+			return nil, fmt.Errorf("Invalid max bandwidth: %v", err)
+		}
+	}
+	if c.ChecksumsPath != "" {
+		if c.BundlePath != "" {
+			return nil, errors.New("-checksums can't be used with -archive.")
+		}
+		if i.checksums, err = readChecksums(c.ChecksumsPath); err != nil {
+			return nil, fmt.Errorf("Invalid checksums file: %v", err)
+		}
+		c.VerifyDownload = true
+	}
+	if c.Input != nil && (c.CheckpointPath != "" || c.BundlePath != "") {
+		return nil, errors.New("-stdin can't be used with -checkpoint or -archive.")
+	}
+	if c.LocalFiles != nil && (c.SourceDir != "" || c.BundlePath != "") {
+		return nil, errors.New("File arguments can't be used with -source-dir or -archive.")
+	}
+	if c.Step <= 0 || c.Step%time.Hour != 0 {
+		return nil, fmt.Errorf("Invalid step: %v (expected a multiple of an hour)", c.Step)
+	}
+	if c.MaxSkipRate < 0 || c.MaxSkipRate >= 1 {
+		return nil, fmt.Errorf("Invalid max skip rate: %g (expected a fraction below 1)", c.MaxSkipRate)
+	}
+	if c.FactorOverflow != "abort" && c.FactorOverflow != "warn" {
+		return nil, fmt.Errorf("Invalid factor overflow action: %s", c.FactorOverflow)
+	}
+	if c.FactorLengthMode != "truncate" && c.FactorLengthMode != "drop" {
+		return nil, fmt.Errorf("Invalid factor length action: %s", c.FactorLengthMode)
+	}
+	if err = validateFactorNumberFormat(c.FactorNumberFormat); err != nil {
+		return nil, err
+	}
+	if c.StrictSchema != "" && c.StrictSchema != "warn" && c.StrictSchema != "error" {
+		return nil, fmt.Errorf("Invalid strict schema mode: %s", c.StrictSchema)
+	}
+	if c.ParseWorkers < 1 {
+		return nil, fmt.Errorf("Invalid parse worker count: %d", c.ParseWorkers)
+	}
+	if c.StreamWorkers < 1 {
+		return nil, fmt.Errorf("Invalid stream worker count: %d", c.StreamWorkers)
+	}
+	if c.StreamBuffer < 0 {
+		return nil, fmt.Errorf("Invalid stream buffer size: %d", c.StreamBuffer)
+	}
+	if c.Truncate < 0 || c.Truncate > time.Hour {
+		return nil, fmt.Errorf("Invalid timestamp resolution: %v", c.Truncate)
+	}
+	if c.DedupeWindow < 0 {
+		return nil, fmt.Errorf("Invalid dedupe window: %d", c.DedupeWindow)
+	}
+	if c.BloomFPRate <= 0 || c.BloomFPRate >= 1 {
+		return nil, fmt.Errorf("Invalid bloom filter false positive rate: %v", c.BloomFPRate)
+	}
+	if err = checkRequiredFields(c.Schema, c.RequiredFields); err != nil {
+		return nil, err
+	}
+	if c.GlobalOrderWindow < 1 {
+		return nil, fmt.Errorf("Invalid global order window: %d", c.GlobalOrderWindow)
+	}
+	if c.BatchSize < 0 {
+		return nil, fmt.Errorf("Invalid batch size: %d", c.BatchSize)
+	}
+	if c.Retries < 0 {
+		return nil, fmt.Errorf("Invalid retries: %d", c.Retries)
+	}
+	if c.Concurrency < 1 {
+		return nil, fmt.Errorf("Invalid concurrency: %d", c.Concurrency)
+	} else if c.Concurrency > 1 && c.GlobalOrder {
+		return nil, errors.New("Global ordering requires -concurrency 1.")
+	}
+	if c.CheckpointPath != "" && c.GlobalOrder {
+		return nil, errors.New("Checkpoints can't be used with global ordering.")
+	}
+	if (c.CountActions || c.CountActionsOnly) && c.CountActionsOutput == "" {
+		return nil, errors.New("-count-actions-output is required with -count-actions.")
+	}
+	if c.MaxMemory != "" {
+		if i.memoryLimit, err = parseByteSize(c.MaxMemory); err != nil {
+			return nil, fmt.Errorf("Invalid max memory: %v", err)
+		}
+	}
+	if c.ReconnectRetries < 0 {
+		return nil, fmt.Errorf("Invalid reconnect retries: %d", c.ReconnectRetries)
+	}
+	if c.MaxIdleConns < 0 || c.IdleConnTimeout < 0 {
+		return nil, fmt.Errorf("Invalid idle connection settings: %d, %v", c.MaxIdleConns, c.IdleConnTimeout)
+	}
+	if c.LogSample < 0 {
+		return nil, fmt.Errorf("Invalid log sample: %d", c.LogSample)
+	}
+	if c.ReorderWindow < 0 {
+		return nil, fmt.Errorf("Invalid reorder window: %d", c.ReorderWindow)
+	} else if c.ReorderWindow > 0 && (c.GlobalOrder || c.TimeWindow > 0) {
+		return nil, errors.New("-reorder-window can't be used with -global-order or -time-window.")
+	}
+	if c.ResumeLines && c.CheckpointPath == "" {
+		return nil, errors.New("-resume-lines requires -checkpoint.")
+	} else if c.ResumeLines && (c.GlobalOrder || c.ReorderWindow > 0 || c.TimeWindow > 0 || c.Presort || c.BatchSize == 0) {
+		return nil, errors.New("-resume-lines can't be used when events are held, such as with -presort, or with -batch-size 0.")
+	}
+	if c.OutputPath != "" && (c.DryRun || c.DualWrite != "") {
+		return nil, errors.New("-output can't be used with -dry-run or -dual-write.")
+	}
+	if c.RejectsPath != "" && c.RejectsPath == c.ReplayRejects {
+		return nil, errors.New("The rejects file can't be the file being replayed.")
+	}
+
+	return i, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Run imports the hours from start through end, Step apart. Returns the
+// outcome of the run and the error that aborted it, if any. Hours that
+// fail don't abort the run unless ContinueOnError is false, and are
+// counted in the stats instead. Cancelling the context interrupts the
+// hours in progress.
+func (i *Importer) Run(ctx context.Context, start time.Time, end time.Time) (*Stats, error) {
+	return i.RunHours(ctx, Hours(start, end, i.config.Step))
+}
+
+// RunHours imports a list of hours, in order. The hours are ignored when
+// reading Input or replaying ReplayRejects.
+func (i *Importer) RunHours(ctx context.Context, dates []time.Time) (*Stats, error) {
+	i.begin()
+	defer i.end()
+	return run(ctx, dates)
+}
+
+// CreateTable creates the table and its properties, or adds the
+// properties an existing table is missing, without importing anything.
+func (i *Importer) CreateTable() error {
+	i.begin()
+	defer i.end()
+	_, _, err := setup()
+	return err
+}
+
+// Probe returns the first and last hours the archive has around a start
+// date.
+func (i *Importer) Probe(ctx context.Context, start time.Time) (time.Time, time.Time, error) {
+	i.begin()
+	defer i.end()
+	return probeArchive(ctx, start)
+}
+
+// ListMissing prints the hours the archive doesn't have to standard output
+// and returns how many there are.
+func (i *Importer) ListMissing(ctx context.Context, dates []time.Time) (int, error) {
+	i.begin()
+	defer i.end()
+	return listMissingHours(ctx, dates)
+}
+
+// DumpUnmapped reports the record fields that aren't read into any
+// property, from a sample of records from the hours. A sample of 0 reads
+// every record.
+func (i *Importer) DumpUnmapped(ctx context.Context, dates []time.Time, sample int) error {
+	i.begin()
+	defer i.end()
+	return dumpUnmappedFields(ctx, dates, sample)
+}
+
+// Stop asks a run to stop once the hours in progress have finished.
+func (i *Importer) Stop(reason string) {
+	requestStop(reason)
+}
+
+// Waits for any other run to finish and sets up the package's state for
+// this one.
+func (i *Importer) begin() {
+	runMutex.Lock()
+	config = i.config
+	checksums = i.checksums
+
+	bandwidth = nil
+	if i.bandwidth > 0 {
+		bandwidth = newBandwidthLimiter(i.bandwidth)
+	}
+	requestTicker = nil
+	if config.MaxRPS > 0 {
+		// Rates too high to pace are treated as unlimited.
+		if interval := time.Duration(float64(time.Second) / config.MaxRPS); interval > 0 {
+			requestTicker = time.NewTicker(interval)
+		}
+	}
+	httpClient = newHTTPClient()
+
+	allowedTypes = nameSet(config.EventTypes, false)
+	allowedUsers = nameSet(config.Users, true)
+	allowedRepos = nameSet(config.Repos, true)
+	strict = nil
+	if config.StrictSchema != "" {
+		strict = newStrictChecker(config.StrictSchema, config.StrictFields)
+	}
+	memory = nil
+	if i.memoryLimit > 0 {
+		memory = newMemoryGuard(i.memoryLimit)
+	}
+	interner = nil
+	if config.CompactData {
+		interner = newStringInterner(maxInternedStrings)
+	}
+	actionTallies = nil
+	if config.CountActions || config.CountActionsOnly {
+		actionTallies = newActionCounter()
+	}
+
+	dualWriter, dualCounts, seenUsers, dedupe = nil, nil, nil, nil
+	checkpoint, lineProgress, rejects, output, reorder = nil, nil, nil, nil, nil
+	stats = &runStats{startTime: time.Now()}
+	atomic.StoreInt64(&sampleCount, 0)
+	atomic.StoreInt64(&limitUsed, 0)
+	atomic.StoreInt32(&lostConnection, 0)
+	atomic.StoreInt64(&rejectedRecords, 0)
+	resetStop()
+}
+
+// Releases the run for the next one.
+func (i *Importer) end() {
+	if requestTicker != nil {
+		requestTicker.Stop()
+	}
+	runMutex.Unlock()
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Runs an import with the package's state set up by begin.
+func run(ctx context.Context, dates []time.Time) (*Stats, error) {
+	var err error
+	defer closeFiles()
+
+	if config.CacheDir != "" && !config.NoCacheWrite {
+		if err = os.MkdirAll(config.CacheDir, 0755); err != nil {
+			return nil, fmt.Errorf("Unable to create cache directory: %v", err)
+		}
+	}
+
+	// Setup the client and table, or the file written instead.
+	var table *sky.Table
+	if config.DryRun {
+		info("Dry run: nothing will be written to Sky.")
+	} else if config.OutputPath != "" {
+		if output, err = newOutput(config.OutputPath); err != nil {
+			return nil, fmt.Errorf("Unable to create output: %v", err)
+		}
+		info("Writing events to %s instead of Sky.", config.OutputPath)
+	} else if _, table, err = setup(); err != nil {
+		return nil, err
+	}
+
+	// Connect the workers that add events to the table.
+	s, err := newStreamer(ctx, config.StreamWorkers)
+	if err != nil {
+		return nil, err
+	}
+
+	// Track factor cardinality across the whole run.
+	guard := newFactorGuard(tableProperties(), config.MaxFactorValues, config.FactorOverflow == "abort")
+	guard.maxLength = config.MaxFactorLength
+	guard.dropLong = (config.FactorLengthMode == "drop")
+
+	// Record the lines that can't be imported.
+	if config.RejectsPath != "" {
+		if rejects, err = newRejectsWriter(config.RejectsPath); err != nil {
+			s.close()
+			return nil, fmt.Errorf("Unable to open rejects file: %v", err)
+		}
+	}
+
+	// Re-import previously rejected records instead of a date range.
+	if config.ReplayRejects != "" {
+		_, err = replayRejectsFile(ctx, s, guard, config.ReplayRejects)
+		s.close()
+		if err == nil {
+			err = closeOutput()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return newStats(0, 0, false), nil
+	}
+
+	// Remember users across the whole run.
+	if config.OnlyNewUsers {
+		seenUsers = newBloomFilter(config.BloomCapacity, config.BloomFPRate)
+	}
+
+	// Remember recent events to skip ones imported again.
+	if config.DedupeWindow > 0 {
+		dedupe = newDedupeSet(config.DedupeWindow)
+	}
+
+	// Merge hours into a single ordered stream if requested.
+	var merger *orderedMerger
+	if config.GlobalOrder {
+		merger = newOrderedMerger(s)
+	}
+
+	// Record the outcome of each hour.
+	var manifest *manifestWriter
+	if config.ManifestPath != "" {
+		if manifest, err = newManifestWriter(config.ManifestPath); err != nil {
+			s.close()
+			return nil, fmt.Errorf("Unable to create manifest: %v", err)
+		}
+		defer manifest.Close()
+	}
+
+	// Skip the hours an earlier run completed.
+	if config.CheckpointPath != "" {
+		if dates, err = resumeCheckpoint(dates); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+
+	// Write events to a file alongside Sky.
+	if config.DualWrite != "" {
+		if dualWriter, err = newOutput(config.DualWrite); err != nil {
+			s.close()
+			return nil, fmt.Errorf("Unable to create dual write file: %v", err)
+		}
+		if config.DualWriteValidate {
+			dualCounts = newDualWriteCounts()
+		}
+	}
+
+	// Loop over date range.
+	if len(dates) > 0 {
+		first, last := dates[0], dates[len(dates)-1]
+		if config.DateLocation != time.UTC {
+			info("Importing %d hours from %s to %s (%s to %s in %s).", len(dates), first.Format(time.RFC3339), last.Format(time.RFC3339), first.In(config.DateLocation).Format(time.RFC3339), last.In(config.DateLocation).Format(time.RFC3339), config.DateLocation)
+		} else {
+			info("Importing %d hours from %s to %s.", len(dates), first.Format(time.RFC3339), last.Format(time.RFC3339))
+		}
+	} else if config.Input == nil {
+		info("No hours to import.")
+	}
+	stats.begin(len(dates))
+	if config.MaxRuntime > 0 {
+		defer stopAfter(config.MaxRuntime).Stop()
+	}
+
+	// Periodically log stage throughput.
+	if config.StatsInterval > 0 {
+		statsCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go logStats(statsCtx, config.StatsInterval)
+	}
+
+	// Show progress for interactive runs.
+	var bar *progressBar
+	if config.ShowProgress {
+		bar = newProgressBar()
+	}
+
+	// Stream status to a monitoring process.
+	if config.ProgressSocket != "" {
+		server, err := newProgressServer(config.ProgressSocket, config.ProgressInterval)
+		if err != nil {
+			s.close()
+			bar.Close()
+			return nil, fmt.Errorf("Unable to open progress socket: %v", err)
+		}
+		defer server.Close()
+	}
+
+	// Serve metrics for Prometheus to scrape.
+	if config.MetricsAddr != "" {
+		server, err := newMetricsServer(ctx, config.MetricsAddr)
+		if err != nil {
+			s.close()
+			bar.Close()
+			return nil, fmt.Errorf("Unable to start metrics server: %v", err)
+		}
+		defer server.Close()
+	}
+
+	var completed, failed int
+	if config.Input != nil {
+		err = importInput(ctx, s, guard, merger, config.Input)
+	} else if config.BundlePath != "" {
+		completed, failed, err = importBundle(ctx, s, guard, merger, manifest, config.BundlePath, dates)
+	} else {
+		// Add hours imported at once in hour order if requested. A bundle
+		// is read one hour at a time so it's always in order.
+		if config.ReorderWindow > 0 && config.Concurrency > 1 {
+			reorder = newReorderBuffer(s, dates, config.ReorderWindow)
+		}
+		completed, failed, err = importHours(ctx, s, guard, merger, manifest, dates)
+	}
+
+	if merger != nil {
+		merger.flushAll()
+	}
+	reorder.flushAll()
+	if actionTallies != nil {
+		var countErr error
+		if config.CountActionsOutput == "sky" {
+			countErr = actionTallies.addEvents(s, table)
+		} else {
+			countErr = actionTallies.writeFile(config.CountActionsOutput)
+		}
+		if countErr != nil {
+			s.close()
+			bar.Close()
+			return nil, fmt.Errorf("Unable to write action counts: %v", countErr)
+		}
+	}
+	s.close()
+	bar.Close()
+	if err := closeOutput(); err != nil {
+		return nil, err
+	}
+	lineProgress.flush()
+	if config.CheckpointPath != "" && !config.DryRun {
+		if err := dedupe.save(dedupePath(config.CheckpointPath)); err != nil {
+			warn("Unable to save dedupe keys: %v", err)
+		}
+	}
+	guard.report()
+	strict.report()
+	memory.report()
+	logSummary()
+
+	closeFiles()
+	mismatched := dualCounts != nil && dualCounts.validate() > 0
+
+	result := newStats(completed, failed, mismatched)
+	switch {
+	case ctx.Err() != nil:
+		warn("Interrupted after importing %d of %d hours.", completed, len(dates))
+	case err == errConnectionLost && checkpoint != nil:
+		logError("Aborting import: lost connection to Sky. Rerun with the same -checkpoint to resume.")
+	case err != nil:
+		logError("Aborting import.")
+	case failed > 0:
+		logError("%d of %d hours failed.", failed, len(dates))
+	case result.HoursSkipped > 0:
+		warn("%d of %d hours were skipped.", result.HoursSkipped, len(dates))
+	}
+	return result, err
+}
+
+// Clears the checkpoint if restarting, reads the dedupe keys saved with it
+// and returns the hours it doesn't mark as done. Unless it's a dry run, the
+// checkpoint is then written as hours complete.
+func resumeCheckpoint(dates []time.Time) ([]time.Time, error) {
+	var err error
+	path := config.CheckpointPath
+	if config.Restart {
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Unable to clear checkpoint: %v", err)
+		}
+		if err = os.Remove(dedupePath(path)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Unable to clear dedupe keys: %v", err)
+		}
+		if err = os.Remove(lineCheckpointPath(path)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Unable to clear line checkpoint: %v", err)
+		}
+	}
+	if dedupe != nil {
+		if err = dedupe.load(dedupePath(path)); err != nil {
+			return nil, fmt.Errorf("Unable to read dedupe keys: %v", err)
+		}
+	}
+	last, err := readCheckpoint(path)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid checkpoint: %v", err)
+	}
+	if remaining := resumeAfter(dates, last); len(remaining) < len(dates) {
+		info("Resuming after %s, %d of %d hours remaining.", last.Format(time.RFC3339), len(remaining), len(dates))
+		dates = remaining
+	}
+	if !config.DryRun {
+		checkpoint = newCheckpointWriter(path, dates)
+		if config.ResumeLines {
+			if lineProgress, err = newLineCheckpoint(lineCheckpointPath(path)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return dates, nil
+}
+
+// Closes the output once every event has been written to it.
+func closeOutput() error {
+	if output == nil {
+		return nil
+	}
+	err := output.Close()
+	output = nil
+	if err != nil {
+		return fmt.Errorf("Unable to close output: %v", err)
+	}
+	return nil
+}
+
+// Closes the files the run writes to that are still open, including the
+// output when setup fails part way.
+func closeFiles() {
+	if output != nil {
+		output.Close()
+		output = nil
+	}
+	if err := rejects.Close(); err != nil {
+		warn("Unable to close rejects file: %v", err)
+	}
+	rejects = nil
+	if dualWriter != nil {
+		if err := dualWriter.Close(); err != nil {
+			warn("Unable to close dual write file: %v", err)
+		}
+		dualWriter = nil
+	}
+}
+
+// Logs the counts of the run and anything dropped along the way.
+func logSummary() {
+	snapshot := stats.snapshot()
+	if snapshot.EventsClamped > 0 {
+		warn("Clamped %d event timestamps into their file's hour.", snapshot.EventsClamped)
+	}
+	for name, n := range snapshot.MissingFields {
+		warn("Dropped %d events missing %s.", n, name)
+	}
+	if snapshot.HoursTimedOut > 0 {
+		warn("%d hours timed out and were skipped.", snapshot.HoursTimedOut)
+	}
+	if n := snapshot.SkipReasons["duplicate"]; n > 0 {
+		info("Skipped %d duplicate events.", n)
+	}
+	if logJSON {
+		logFields{"read": snapshot.EventsRead, "added": snapshot.EventsAdded, "skipped": snapshot.EventsSkipped, "skip_reasons": snapshot.SkipReasons}.info("Import summary.")
+	} else {
+		stats.writeSummary(logOutput)
+	}
+	if config.DryRun {
+		info("Dry run: would import %s events; skipped %s.", formatCount(snapshot.EventsAdded), snapshot.skipSummary())
+	}
+}
+
+// Returns the outcome of the run from its stats, the number of hours the
+// workers completed and failed, and whether the dual write counts matched.
+func newStats(completed int, failed int, mismatched bool) *Stats {
+	snapshot := stats.snapshot()
+	failedHours, skippedHours := stats.incompleteHours()
+	return &Stats{
+		StartTime:      snapshot.StartTime,
+		EndTime:        time.Now().UTC(),
+		HoursTotal:     snapshot.HoursTotal,
+		HoursCompleted: completed,
+		HoursFailed:    len(failedHours),
+		HoursSkipped:   len(skippedHours),
+		FailedHours:    failedHours,
+		SkippedHours:   skippedHours,
+		EventsRead:     snapshot.EventsRead,
+		EventsAdded:    snapshot.EventsAdded,
+		EventsSkipped:  snapshot.EventsSkipped,
+		SkipReasons:    snapshot.SkipReasons,
+		Incomplete:     failed > 0 || len(skippedHours) > 0 || mismatched,
+	}
+}
+
+// Hours returns the hours from start through end, a step apart.
+func Hours(start time.Time, end time.Time, step time.Duration) []time.Time {
+	var dates []time.Time
+	for date := start.UTC().Truncate(time.Hour); !date.After(end); date = date.Add(step) {
+		dates = append(dates, date)
+	}
+	return dates
+}
@@ -0,0 +1,44 @@
+package importer
+
+import (
+	"testing"
+	"time"
+)
+
+// Ensures that New rejects invalid options and fills in missing defaults.
+func TestNew(t *testing.T) {
+	config := DefaultConfig()
+	config.Schema = nil
+	config.DateLocation = nil
+	imp, err := New(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if imp.config.Schema == nil || imp.config.DateLocation != time.UTC {
+		t.Fatalf("Defaults not set: %v, %v", imp.config.Schema, imp.config.DateLocation)
+	}
+
+	var tests = []func(*Config){
+		func(c *Config) { c.Step = 90 * time.Minute },
+		func(c *Config) { c.MaxRPS = -1 },
+		func(c *Config) { c.MaxSkipRate = 1 },
+		func(c *Config) { c.LocalFiles = map[time.Time]string{}; c.SourceDir = "archive" },
+	}
+	for i, f := range tests {
+		config := DefaultConfig()
+		f(&config)
+		if _, err := New(config); err == nil {
+			t.Fatalf("%d. Expected an error", i)
+		}
+	}
+}
+
+// Ensures that Hours includes both ends of the range.
+func TestHours(t *testing.T) {
+	start := time.Date(2013, 1, 1, 22, 0, 0, 0, time.UTC)
+	end := time.Date(2013, 1, 2, 2, 0, 0, 0, time.UTC)
+	dates := Hours(start, end, 2*time.Hour)
+	if len(dates) != 3 || !dates[0].Equal(start) || !dates[2].Equal(end) {
+		t.Fatalf("Unexpected hours: %v", dates)
+	}
+}
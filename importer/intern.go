@@ -0,0 +1,74 @@
+package importer
+
+import (
+	"sync"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The most distinct strings kept by -compact-data. Past this, new values
+// are passed through so a high-cardinality factor can't grow the table
+// without bound.
+const maxInternedStrings = 100000
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// stringInterner hands out one shared copy of each distinct string so that
+// factor values repeated across an hour, such as "PushEvent", don't keep
+// their own copy of the decoded record alive in every held event. It is
+// safe for concurrent use.
+type stringInterner struct {
+	mutex  sync.RWMutex
+	values map[string]string
+	limit  int
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates an interner that keeps up to limit strings.
+func newStringInterner(limit int) *stringInterner {
+	return &stringInterner{values: map[string]string{}, limit: limit}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Returns the shared copy of a string, keeping this one if it hasn't been
+// seen. A nil interner returns the string unchanged.
+func (i *stringInterner) intern(s string) string {
+	if i == nil {
+		return s
+	}
+
+	i.mutex.RLock()
+	shared, ok := i.values[s]
+	i.mutex.RUnlock()
+	if ok {
+		return shared
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if shared, ok := i.values[s]; ok {
+		return shared
+	} else if len(i.values) >= i.limit {
+		return s
+	}
+	i.values[s] = s
+	return s
+}
@@ -0,0 +1,69 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// Ensures that equal strings share one copy up to the limit.
+func TestStringInterner(t *testing.T) {
+	i := newStringInterner(2)
+	a := i.intern(string([]byte("PushEvent")))
+	b := i.intern(string([]byte("PushEvent")))
+	if unsafe.StringData(a) != unsafe.StringData(b) {
+		t.Fatal("Expected a shared copy")
+	}
+	i.intern("WatchEvent")
+	c := i.intern(string([]byte("ForkEvent")))
+	if d := i.intern(string([]byte("ForkEvent"))); unsafe.StringData(c) == unsafe.StringData(d) {
+		t.Fatal("Expected no copy past the limit")
+	}
+	var none *stringInterner
+	if none.intern("Go") != "Go" {
+		t.Fatal("Expected a nil interner to pass strings through")
+	}
+}
+
+// Parses an hour of records and holds every event, as -presort does,
+// reporting the heap still in use per event with and without
+// -compact-data.
+func BenchmarkNewEventHeld(b *testing.B) {
+	types := []string{"PushEvent", "WatchEvent", "CreateEvent", "IssuesEvent", "ForkEvent", "PullRequestEvent"}
+	languages := []string{"JavaScript", "Ruby", "Python", "Java", "Go", "C", "PHP", "Shell"}
+	lines := make([][]byte, 20000)
+	for n := range lines {
+		lines[n] = []byte(fmt.Sprintf(`{"type":%q,"actor":"user%d","created_at":"2013-01-01T00:00:00Z","repository":{"language":%q,"forks":%d,"size":%d}}`, types[n%len(types)], n, languages[n%len(languages)], n%50, n))
+	}
+	timestamp := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, compact := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compact=%v", compact), func(b *testing.B) {
+			defer func() { interner = nil }()
+			for n := 0; n < b.N; n++ {
+				interner = nil
+				if compact {
+					interner = newStringInterner(maxInternedStrings)
+				}
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				events := make([]interface{}, len(lines))
+				for j, line := range lines {
+					var data map[string]interface{}
+					if err := json.Unmarshal(line, &data); err != nil {
+						b.Fatal(err)
+					}
+					events[j] = newEvent(data, timestamp)
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(len(lines)), "held-B/event")
+				runtime.KeepAlive(events)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// Log levels, from least to most verbose.
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// logLevel is the severity of a log message.
+type logLevel int
+
+// logFields are structured values attached to a log message, such as the
+// hour's URL or a line number. In text format they are appended to the
+// message as key=value pairs.
+type logFields map[string]interface{}
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+var logLevelNames = []string{"error", "warn", "info", "debug"}
+
+// The most verbose level written and whether lines are written as JSON.
+var currentLogLevel = levelInfo
+var logJSON bool
+
+var logMutex sync.Mutex
+var logOutput io.Writer = os.Stderr
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+// Logs an error with the fields.
+func (f logFields) error(msg string, v ...interface{}) {
+	writeLog(levelError, f, msg, v...)
+}
+
+// Logs a warning with the fields.
+func (f logFields) warn(msg string, v ...interface{}) {
+	writeLog(levelWarn, f, msg, v...)
+}
+
+// Logs an informational message with the fields.
+func (f logFields) info(msg string, v ...interface{}) {
+	writeLog(levelInfo, f, msg, v...)
+}
+
+// Logs a debug message with the fields.
+func (f logFields) debug(msg string, v ...interface{}) {
+	writeLog(levelDebug, f, msg, v...)
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Parses a log level name.
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("Invalid log level: %s", s)
+}
+
+// SetLogOptions sets the most verbose level logged, one of error, warn,
+// info or debug, and the format of log lines, text or json. It applies to
+// every message, including those of the command using the package.
+func SetLogOptions(level string, format string) error {
+	l, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("Invalid log format: %s", format)
+	}
+
+	currentLogLevel, logJSON = l, (format == "json")
+	return nil
+}
+
+// LogError logs an error in the configured format.
+func LogError(msg string, v ...interface{}) {
+	writeLog(levelError, nil, msg, v...)
+}
+
+// LogWarn logs a warning in the configured format.
+func LogWarn(msg string, v ...interface{}) {
+	writeLog(levelWarn, nil, msg, v...)
+}
+
+// LogInfo logs an informational message in the configured format.
+func LogInfo(msg string, v ...interface{}) {
+	writeLog(levelInfo, nil, msg, v...)
+}
+
+// Logs an error.
+func logError(msg string, v ...interface{}) {
+	writeLog(levelError, nil, msg, v...)
+}
+
+// Logs a warning.
+func warn(msg string, v ...interface{}) {
+	writeLog(levelWarn, nil, msg, v...)
+}
+
+// Logs an informational message.
+func info(msg string, v ...interface{}) {
+	writeLog(levelInfo, nil, msg, v...)
+}
+
+// Logs a debug message, shown with -log-level debug or -v.
+func debug(msg string, v ...interface{}) {
+	writeLog(levelDebug, nil, msg, v...)
+}
+
+// Writes a log line to standard error if the level is enabled.
+func writeLog(level logLevel, fields logFields, msg string, v ...interface{}) {
+	if level > currentLogLevel {
+		return
+	}
+	msg = strings.TrimRight(fmt.Sprintf(msg, v...), "\n")
+
+	var line []byte
+	if logJSON {
+		entry := map[string]interface{}{}
+		for k, v := range fields {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			entry[k] = v
+		}
+		entry["timestamp"] = time.Now().UTC().Format(time.RFC3339Nano)
+		entry["level"] = level.String()
+		entry["message"] = msg
+		line, _ = json.Marshal(entry)
+	} else {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			msg += fmt.Sprintf(" %s=%v", k, fields[k])
+		}
+		line = []byte(msg)
+	}
+
+	// Write above the progress bar, if there is one, and draw it again.
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if activeBar != nil {
+		io.WriteString(logOutput, "\r\033[K")
+	}
+	logOutput.Write(append(line, '\n'))
+	if activeBar != nil {
+		io.WriteString(logOutput, activeBar.line)
+	}
+}
@@ -0,0 +1,196 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// Hour statuses recorded in the manifest.
+const (
+	hourOK      = "ok"
+	hourFailed  = "failed"
+	hourSkipped = "skipped"
+)
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+// Returned when an hour takes longer than -hour-timeout.
+var errHourTimedOut = errors.New("Hour timed out.")
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+var retryListMutex sync.Mutex
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// manifestEntry records the outcome of a single hour.
+type manifestEntry struct {
+	Hour   time.Time `json:"hour"`
+	URL    string    `json:"url"`
+	Status string    `json:"status"`
+	Events int       `json:"events"`
+	SHA256 string    `json:"sha256,omitempty"`
+	Bytes  int64     `json:"bytes,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// manifestDigest is the checksum of an hour's archive file.
+type manifestDigest struct {
+	sum  string
+	size int64
+}
+
+// manifestWriter writes one JSON entry per processed hour. It is safe for
+// concurrent use.
+type manifestWriter struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	digests map[time.Time]manifestDigest
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a manifest file, replacing any existing file.
+func newManifestWriter(path string) (*manifestWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestWriter{file: file, encoder: json.NewEncoder(file), digests: map[time.Time]manifestDigest{}}, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Records the outcome of an hour. A nil writer does nothing.
+func (m *manifestWriter) write(date time.Time, events int, status string, err error) {
+	if m == nil {
+		return
+	}
+
+	entry := &manifestEntry{Hour: date, URL: archiveURL(date), Status: status, Events: events}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if digest, ok := m.digests[date]; ok {
+		entry.SHA256, entry.Bytes = digest.sum, digest.size
+		delete(m.digests, date)
+	}
+	if err := m.encoder.Encode(entry); err != nil {
+		warn("Unable to write manifest: %v", err)
+	}
+}
+
+// Remembers the checksum and size of an hour's archive file for its entry.
+// A nil writer does nothing.
+func (m *manifestWriter) digest(date time.Time, sum string, size int64) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.digests[date] = manifestDigest{sum, size}
+}
+
+// Closes the manifest file.
+func (m *manifestWriter) Close() error {
+	if m == nil {
+		return nil
+	}
+	return m.file.Close()
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// RetryHours reads a -manifest file and returns the hours whose most
+// recent entry was not successful, in ascending order.
+func RetryHours(path string) ([]time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	statuses := map[time.Time]string{}
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		entry := &manifestEntry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			return nil, err
+		}
+		statuses[entry.Hour.UTC()] = entry.Status
+	}
+
+	var dates []time.Time
+	for date, status := range statuses {
+		if status != hourOK {
+			dates = append(dates, date)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates, nil
+}
+
+// Appends an hour to a retry list, a file of hours in the format read by
+// -hours-file.
+func appendRetryList(path string, date time.Time) error {
+	retryListMutex.Lock()
+	defer retryListMutex.Unlock()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(date.UTC().Format(time.RFC3339) + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
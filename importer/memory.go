@@ -0,0 +1,148 @@
+package importer
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The number of events parsed between heap samples. Reading the memory
+// stats briefly stops the world so it isn't done for every event.
+const memoryCheckInterval = 10000
+
+// The fraction of -max-memory at which held events are flushed early.
+const memoryHighWater = 0.9
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// Byte size suffixes accepted by -max-memory, longest first.
+var byteSizeUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// memoryGuard samples the heap while events are parsed and reports when it
+// is close to a limit so held events can be written and freed before the
+// process is killed. It is safe for concurrent use.
+type memoryGuard struct {
+	mutex   sync.Mutex
+	limit   uint64
+	flushes int
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a guard for a heap limit in bytes.
+func newMemoryGuard(limit uint64) *memoryGuard {
+	return &memoryGuard{limit: limit}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Samples the heap and returns true if it is near the limit, in which case
+// the caller should write what it holds and call relieve. A nil guard is
+// never under pressure.
+func (g *memoryGuard) underPressure() bool {
+	if g == nil {
+		return false
+	}
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if float64(m.HeapAlloc) < memoryHighWater*float64(g.limit) {
+		return false
+	}
+
+	g.mutex.Lock()
+	g.flushes++
+	g.mutex.Unlock()
+	logFields{"heap": formatByteSize(m.HeapAlloc), "limit": formatByteSize(g.limit)}.warn("Memory near limit, flushing held events early.")
+	return true
+}
+
+// Collects garbage after held events have been written, returning freed
+// memory to the operating system.
+func (g *memoryGuard) relieve() {
+	runtime.GC()
+}
+
+// Logs how often events were flushed early. A nil guard does nothing.
+func (g *memoryGuard) report() {
+	if g == nil {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.flushes > 0 {
+		warn("Flushed held events early %d times to stay under %s.", g.flushes, formatByteSize(g.limit))
+	}
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Parses a byte size such as "2GB", "512MB" or "1048576". Units are powers
+// of 1024, and a size must come to at least one byte.
+func parseByteSize(s string) (uint64, error) {
+	number := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := uint64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	size := n * float64(multiplier)
+	if err != nil || math.IsNaN(size) || size < 1 || size >= math.MaxUint64 {
+		return 0, fmt.Errorf("Invalid size: %s", s)
+	}
+	return uint64(size), nil
+}
+
+// Formats a byte size with the largest unit that fits.
+func formatByteSize(n uint64) string {
+	for _, unit := range byteSizeUnits {
+		if n >= unit.size && unit.size > 1 {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(unit.size), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
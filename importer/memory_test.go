@@ -0,0 +1,30 @@
+package importer
+
+import (
+	"testing"
+)
+
+// Ensures that byte sizes are parsed with or without a unit.
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		size uint64
+		ok   bool
+	}{
+		{"2GB", 2 << 30, true},
+		{"512mb", 512 << 20, true},
+		{"1.5 KB", 1536, true},
+		{"1048576", 1 << 20, true},
+		{"GB", 0, false},
+		{"-1GB", 0, false},
+		{"0.5", 0, false},
+		{"0.0001KB", 0, false},
+		{"NaN", 0, false},
+		{"lots", 0, false},
+	}
+	for _, tt := range tests {
+		if size, err := parseByteSize(tt.s); (err == nil) != tt.ok || size != tt.size {
+			t.Errorf("%s: unexpected size: %d, %v", tt.s, size, err)
+		}
+	}
+}
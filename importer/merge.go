@@ -0,0 +1,130 @@
+package importer
+
+import (
+	"container/heap"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// orderedMerger merges events from consecutive hours into one stream that
+// is committed in timestamp order. Events are held until the watermark
+// passes them so stragglers from the next hour's file can be placed
+// before them. Memory is bounded to the events of the last few hours.
+type orderedMerger struct {
+	streamer  *streamer
+	events    mergeHeap
+	seq       int
+	watermark time.Time
+	late      int
+}
+
+// mergeItem is an event held by the merger. The sequence number keeps
+// events with equal timestamps in arrival order.
+type mergeItem struct {
+	*userEvent
+	seq int
+}
+
+// mergeHeap is a min-heap of held events ordered by timestamp.
+type mergeHeap []*mergeItem
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+func newOrderedMerger(s *streamer) *orderedMerger {
+	return &orderedMerger{streamer: s}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Holds an event until the watermark passes it. Events older than the
+// watermark can no longer be ordered so they are committed immediately
+// and counted as late.
+func (m *orderedMerger) push(e *userEvent) {
+	if e.event.Timestamp.Before(m.watermark) {
+		m.late++
+		commit(m.streamer, userEvents{e}, nil)
+		return
+	}
+	m.seq++
+	heap.Push(&m.events, &mergeItem{e, m.seq})
+}
+
+// Commits all held events with a timestamp before a given time, in order.
+// When committing by time window the watermark is aligned to a window
+// boundary so that a window is never split across two flushes.
+func (m *orderedMerger) flush(until time.Time) {
+	if config.TimeWindow > 0 {
+		until = until.Truncate(config.TimeWindow)
+	}
+	if until.After(m.watermark) {
+		m.watermark = until
+	}
+
+	var events userEvents
+	for len(m.events) > 0 && m.events[0].event.Timestamp.Before(m.watermark) {
+		events = append(events, heap.Pop(&m.events).(*mergeItem).userEvent)
+	}
+	m.commit(events)
+}
+
+// Commits every held event.
+func (m *orderedMerger) flushAll() {
+	var events userEvents
+	for len(m.events) > 0 {
+		events = append(events, heap.Pop(&m.events).(*mergeItem).userEvent)
+	}
+	m.commit(events)
+
+	if m.late > 0 {
+		warn("%d events arrived after their position in the stream and were committed out of order.", m.late)
+	}
+}
+
+// Commits sorted events, by time window if one is configured.
+func (m *orderedMerger) commit(events userEvents) {
+	if config.TimeWindow > 0 {
+		commitWindows(m.streamer, events, config.TimeWindow)
+	} else {
+		commit(m.streamer, events, nil)
+	}
+}
+
+func (h mergeHeap) Len() int {
+	return len(h)
+}
+
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].event.Timestamp.Equal(h[j].event.Timestamp) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].event.Timestamp.Before(h[j].event.Timestamp)
+}
+
+func (h mergeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *mergeHeap) Push(x interface{}) {
+	*h = append(*h, x.(*mergeItem))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
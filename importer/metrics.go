@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// metricsServer serves the run counters over HTTP in the Prometheus text
+// exposition format.
+type metricsServer struct {
+	server *http.Server
+}
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Starts serving metrics at /metrics on an address such as ":9100". The
+// server is shut down when the context is cancelled.
+func newMetricsServer(ctx context.Context, addr string) (*metricsServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		stats.writeMetrics(w)
+	})
+
+	s := &metricsServer{server: &http.Server{Handler: mux}}
+	go s.server.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+	return s, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Stops the server, letting in-flight scrapes finish.
+func (s *metricsServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// Writes the counters as Prometheus metrics.
+func (s *runStats) writeMetrics(w io.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	writeMetric(w, "gharchive_events_read_total", "counter", "Records read from archive files.", s.eventsRead)
+	writeMetric(w, "gharchive_events_added_total", "counter", "Events added to Sky.", s.eventsAdded)
+
+	skipped := map[string]int{}
+	for reason, n := range s.skipReasons {
+		skipped[reason] = n
+	}
+	for name, n := range s.missingFields {
+		skipped["missing "+name] = n
+	}
+	var reasons []string
+	for reason := range skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	fmt.Fprintf(w, "# HELP gharchive_events_skipped_total Records not imported, by reason.\n")
+	fmt.Fprintf(w, "# TYPE gharchive_events_skipped_total counter\n")
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "gharchive_events_skipped_total{reason=\"%s\"} %d\n", labelEscaper.Replace(reason), skipped[reason])
+	}
+
+	writeMetric(w, "gharchive_hours", "gauge", "Hours in the run.", s.hoursTotal)
+	writeMetric(w, "gharchive_hours_done_total", "counter", "Hours finished, successfully or not.", s.hoursDone)
+	writeMetric(w, "gharchive_hours_failed_total", "counter", "Hours that failed to import.", s.hoursFailed)
+	writeMetric(w, "gharchive_hours_timed_out_total", "counter", "Hours abandoned after -hour-timeout.", s.hoursTimedOut)
+
+	var current int64
+	if date := s.currentHour(); !date.IsZero() {
+		current = date.Unix()
+	}
+	writeMetric(w, "gharchive_current_hour_timestamp_seconds", "gauge", "The earliest hour being imported, as a Unix timestamp.", current)
+
+	h := s.hourDurations
+	fmt.Fprintf(w, "# HELP gharchive_hour_duration_seconds Time taken to download and import an hour.\n")
+	fmt.Fprintf(w, "# TYPE gharchive_hour_duration_seconds histogram\n")
+	cumulative := 0
+	for i, bound := range hourDurationBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		fmt.Fprintf(w, "gharchive_hour_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "gharchive_hour_duration_seconds_bucket{le=\"+Inf\"} %d\n", h.count)
+	fmt.Fprintf(w, "gharchive_hour_duration_seconds_sum %s\n", strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "gharchive_hour_duration_seconds_count %d\n", h.count)
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Writes a single unlabeled metric with its help and type.
+func writeMetric(w io.Writer, name string, kind string, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
@@ -0,0 +1,165 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Checks every hour with a HEAD request, or for a file in the source
+// directory, and prints the hours that are not available. Nothing is
+// downloaded. Only an hour the archive reports as not found is missing;
+// other failures are retried like downloads and, if they persist, logged
+// and returned as an error rather than reported as gaps. Returns the
+// number of missing hours.
+func listMissingHours(ctx context.Context, dates []time.Time) (int, error) {
+	missing, failed := 0, 0
+	for _, date := range dates {
+		if ctx.Err() != nil {
+			break
+		}
+
+		url := archiveURL(date)
+		ok, err := hourAvailable(ctx, date)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logFields{"url": url, "error": err}.error("Unable to check archive.")
+			failed++
+		} else if !ok {
+			fmt.Printf("%s\t%s\tnot found\n", date.Format(time.RFC3339), url)
+			missing++
+		}
+	}
+
+	info("%d of %d hours missing.", missing, len(dates))
+	if failed > 0 {
+		return missing, fmt.Errorf("Unable to check %d of %d hours.", failed, len(dates))
+	}
+	return missing, ctx.Err()
+}
+
+// Finds the first and last hours available in the archive around a start
+// hour that is available. Each direction steps out in doubling jumps until
+// an hour is missing and then narrows down on the boundary, so only a few
+// dozen HEAD requests are made even over years of hours. The archive is
+// assumed to be continuous between the two, so isolated gaps can be jumped
+// over; use -list-missing to find them.
+func probeArchive(ctx context.Context, start time.Time) (time.Time, time.Time, error) {
+	ok, err := hourAvailable(ctx, start)
+	if err != nil {
+		return start, start, err
+	} else if !ok {
+		return start, start, fmt.Errorf("No archive for %s, try a later start date.", start.Format(time.RFC3339))
+	}
+
+	latest := time.Now().UTC().Truncate(time.Hour)
+	last, err := probeBoundary(ctx, start, 1, int(latest.Sub(start)/time.Hour))
+	if err != nil {
+		return start, start, err
+	}
+	first, err := probeBoundary(ctx, start, -1, int(start.Sub(time.Unix(0, 0))/time.Hour))
+	if err != nil {
+		return start, start, err
+	}
+	return first, last, nil
+}
+
+// Returns the furthest available hour from an available one in a direction
+// of +1 or -1 hours, looking at most max hours away.
+func probeBoundary(ctx context.Context, from time.Time, direction int, max int) (time.Time, error) {
+	at := func(offset int) time.Time {
+		return from.Add(time.Duration(direction*offset) * time.Hour)
+	}
+
+	// Jump out until an hour is missing. The boundary then lies between
+	// the last available hour and the missing one.
+	found, missing := 0, -1
+	for step := 1; found < max; step *= 2 {
+		offset := found + step
+		if offset > max {
+			offset = max
+		}
+		ok, err := hourAvailable(ctx, at(offset))
+		if err != nil {
+			return from, err
+		} else if !ok {
+			missing = offset
+			break
+		}
+		found = offset
+	}
+	if missing == -1 {
+		return at(found), nil
+	}
+
+	for missing-found > 1 {
+		offset := found + (missing-found)/2
+		ok, err := hourAvailable(ctx, at(offset))
+		if err != nil {
+			return from, err
+		} else if ok {
+			found = offset
+		} else {
+			missing = offset
+		}
+	}
+	return at(found), nil
+}
+
+// Returns true if the archive has a file for an hour, checking with a HEAD
+// request or for the file in the source directory. Network errors and
+// server errors are retried as for downloads.
+func hourAvailable(ctx context.Context, date time.Time) (bool, error) {
+	url := archiveURL(date)
+	if readingLocalFiles() {
+		_, err := os.Stat(url)
+		return err == nil, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		ok, retryable, err := headArchive(ctx, url)
+		if err == nil || !retryable || attempt >= config.Retries || ctx.Err() != nil {
+			return ok, err
+		}
+
+		delay := retryDelay(attempt)
+		logFields{"url": url, "error": err}.warn("Retrying in %v (%d/%d).", delay, attempt+1, config.Retries)
+		if !sleepContext(ctx, delay) {
+			return false, ctx.Err()
+		}
+	}
+}
+
+// Makes a single HEAD request for an archive. Returns whether it was found
+// and, on failure, whether the failure is worth retrying.
+func headArchive(ctx context.Context, url string) (bool, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return false, false, err
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		return false, true, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		logFields{"url": url}.debug("Archive found.")
+		return true, false, nil
+	case http.StatusNotFound:
+		logFields{"url": url}.debug("Archive not found.")
+		return false, false, nil
+	}
+	return false, resp.StatusCode >= 500, fmt.Errorf("Unexpected status for %s: %s", url, resp.Status)
+}
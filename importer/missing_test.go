@@ -0,0 +1,83 @@
+package importer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Ensures that probing finds both ends of the available hours.
+func TestProbeArchive(t *testing.T) {
+	first := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(2013, 3, 2, 17, 0, 0, 0, time.UTC)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if date, ok := bundleEntryHour(req.URL.Path); !ok || date.Before(first) || date.After(last) {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer func(s string) { config.BaseURL = s }(config.BaseURL)
+	config.BaseURL = server.URL
+
+	for _, start := range []time.Time{first, last, time.Date(2013, 2, 14, 9, 0, 0, 0, time.UTC)} {
+		requests = 0
+		f, l, err := probeArchive(context.Background(), start)
+		if err != nil {
+			t.Fatalf("%v: %v", start, err)
+		} else if !f.Equal(first) || !l.Equal(last) {
+			t.Errorf("%v: unexpected range: %v - %v", start, f, l)
+		} else if requests > 100 {
+			t.Errorf("%v: too many requests: %d", start, requests)
+		}
+	}
+
+	if _, _, err := probeArchive(context.Background(), last.Add(time.Hour)); err == nil {
+		t.Fatal("Expected an error for a missing start hour.")
+	}
+}
+
+// Ensures that only hours the archive reports as not found are missing,
+// that server errors are retried and that other failures are errors
+// rather than gaps.
+func TestListMissingHours(t *testing.T) {
+	defer func(s string, n int, d time.Duration) {
+		config.BaseURL, config.Retries, config.RetryBaseDelay = s, n, d
+	}(config.BaseURL, config.Retries, config.RetryBaseDelay)
+
+	start := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	statuses := map[int][]int{
+		1: {http.StatusNotFound},
+		2: {http.StatusServiceUnavailable, http.StatusOK},
+		3: {http.StatusForbidden, http.StatusOK},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		date, _ := bundleEntryHour(req.URL.Path)
+		hour := int(date.Sub(start) / time.Hour)
+		if s := statuses[hour]; len(s) > 0 {
+			w.WriteHeader(s[0])
+			statuses[hour] = s[1:]
+		}
+	}))
+	defer server.Close()
+	config.BaseURL, config.Retries, config.RetryBaseDelay = server.URL, 1, time.Millisecond
+
+	var dates []time.Time
+	for i := 0; i < 4; i++ {
+		dates = append(dates, start.Add(time.Duration(i)*time.Hour))
+	}
+	missing, err := listMissingHours(context.Background(), dates)
+	if missing != 1 {
+		t.Errorf("expected 1 missing hour, got %d", missing)
+	}
+	if err == nil || !strings.Contains(err.Error(), "1 of 4") {
+		t.Errorf("expected an error for the forbidden hour, got %v", err)
+	}
+	if len(statuses[2]) != 0 || len(statuses[3]) != 1 {
+		t.Errorf("unexpected retries: %v", statuses)
+	}
+}
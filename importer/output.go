@@ -0,0 +1,199 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// eventWriter writes events to a file as newline-delimited JSON. It is
+// safe for concurrent use.
+//
+// In canonical mode the output is byte-for-byte reproducible: object keys
+// are sorted, HTML characters are not escaped, timestamps are in UTC and
+// numbers never use exponents.
+type eventWriter struct {
+	mutex     sync.Mutex
+	file      *os.File
+	w         *bufio.Writer
+	canonical bool
+}
+
+// eventRecord is the JSON representation of an event in an output file.
+type eventRecord struct {
+	Username  string                 `json:"username"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates an event file, replacing any existing file.
+func newEventWriter(path string) (*eventWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return newEventFileWriter(file), nil
+}
+
+// Creates an event writer over an open file.
+func newEventFileWriter(file *os.File) *eventWriter {
+	return &eventWriter{file: file, w: bufio.NewWriter(file)}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Writes a single event as a line of JSON.
+func (w *eventWriter) write(e *userEvent) error {
+	var b []byte
+	var err error
+	if w.canonical {
+		b, err = encodeCanonical(map[string]interface{}{
+			"username":  e.username,
+			"timestamp": e.event.Timestamp.UTC().Format(time.RFC3339Nano),
+			"data":      e.event.Data,
+		})
+	} else {
+		b, err = json.Marshal(&eventRecord{e.username, e.event.Timestamp, e.event.Data})
+	}
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, err = w.w.Write(b); err != nil {
+		return err
+	}
+	return w.w.WriteByte('\n')
+}
+
+// Flushes buffered events and closes the file.
+func (w *eventWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.w.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Encodes a value as canonical JSON: keys are sorted, strings are not
+// HTML-escaped and numbers are written in plain decimal notation.
+func encodeCanonical(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeCanonicalJSON(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonicalJSON(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		return writeCanonicalString(buf, v)
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("Unsupported number: %v", v)
+		}
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return writeCanonicalJSON(buf, f)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalString(buf, key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeCanonicalJSON(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalJSON(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		// Round trip anything else through the standard encoder.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err = json.Unmarshal(b, &generic); err != nil {
+			return err
+		}
+		return writeCanonicalJSON(buf, generic)
+	}
+	return nil
+}
+
+// Writes a JSON string without escaping HTML characters.
+func writeCanonicalString(buf *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1) // Drop the encoder's newline.
+	return nil
+}
@@ -0,0 +1,161 @@
+package importer
+
+import (
+	"container/list"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The partition value used for events without a value, following Hive.
+const defaultPartitionValue = "__HIVE_DEFAULT_PARTITION__"
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// eventOutput is a destination for event records.
+type eventOutput interface {
+	write(e *userEvent) error
+	Close() error
+}
+
+// partitionWriter writes events into a Hive-style directory layout,
+// partitioned by the value of a property and then by event hour:
+//
+//	DIR/language=Go/2013-01-01-15.ndjson
+//
+// Only a limited number of files are kept open at once. When the limit is
+// reached the least recently used file is closed and reopened for append
+// if it is needed again.
+type partitionWriter struct {
+	mutex     sync.Mutex
+	dir       string
+	property  string
+	maxOpen   int
+	canonical bool
+	writers   map[string]*list.Element
+	lru       *list.List
+	created   map[string]bool
+}
+
+// partitionFile is an open partition file.
+type partitionFile struct {
+	path string
+	w    *eventWriter
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+func newPartitionWriter(dir string, property string, maxOpen int) (*partitionWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if maxOpen < 1 {
+		maxOpen = 1
+	}
+	return &partitionWriter{
+		dir:      dir,
+		property: property,
+		maxOpen:  maxOpen,
+		writers:  map[string]*list.Element{},
+		lru:      list.New(),
+		created:  map[string]bool{},
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Writes an event to its partition file.
+func (p *partitionWriter) write(e *userEvent) error {
+	value := defaultPartitionValue
+	if v, ok := e.event.Data[p.property]; ok && v != nil && v != "" {
+		value = url.PathEscape(fmt.Sprint(v))
+	}
+	path := filepath.Join(p.dir, p.property+"="+value, e.event.Timestamp.UTC().Format("2006-01-02-15")+".ndjson")
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	w, err := p.writer(path)
+	if err != nil {
+		return err
+	}
+	return w.write(e)
+}
+
+// Returns the open writer for a path, opening it and closing the least
+// recently used writer if necessary.
+func (p *partitionWriter) writer(path string) (*eventWriter, error) {
+	if elem, ok := p.writers[path]; ok {
+		p.lru.MoveToFront(elem)
+		return elem.Value.(*partitionFile).w, nil
+	}
+
+	for p.lru.Len() >= p.maxOpen {
+		if err := p.evict(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	// Truncate a file the first time it's opened in this run.
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !p.created[path] {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	p.created[path] = true
+
+	w := newEventFileWriter(file)
+	w.canonical = p.canonical
+	p.writers[path] = p.lru.PushFront(&partitionFile{path, w})
+	return w, nil
+}
+
+// Closes the least recently used writer.
+func (p *partitionWriter) evict() error {
+	elem := p.lru.Back()
+	f := elem.Value.(*partitionFile)
+	p.lru.Remove(elem)
+	delete(p.writers, f.path)
+	return f.w.Close()
+}
+
+// Closes all open partition files.
+func (p *partitionWriter) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var err error
+	for p.lru.Len() > 0 {
+		if e := p.evict(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package importer
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// progressServer writes a JSON status line to every client connected to a
+// Unix domain socket at a fixed interval.
+type progressServer struct {
+	mutex    sync.Mutex
+	listener net.Listener
+	conns    []net.Conn
+	done     chan bool
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Starts serving progress on a Unix socket. A stale socket file at the
+// path is removed first.
+func newProgressServer(path string, interval time.Duration) (*progressServer, error) {
+	if _, err := os.Stat(path); err == nil {
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &progressServer{listener: listener, done: make(chan bool)}
+	go s.accept()
+	go s.run(interval)
+	return s, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Accepts monitoring clients until the listener is closed.
+func (s *progressServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.conns = append(s.conns, conn)
+		s.mutex.Unlock()
+	}
+}
+
+// Broadcasts the current status every interval until closed.
+func (s *progressServer) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.broadcast()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Writes the current status to all clients, dropping any that have gone
+// away.
+func (s *progressServer) broadcast() {
+	b, err := json.Marshal(stats.snapshot())
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	conns := s.conns[:0]
+	for _, conn := range s.conns {
+		if _, err := conn.Write(b); err != nil {
+			conn.Close()
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	s.conns = conns
+}
+
+// Sends a final status, disconnects all clients and removes the socket.
+func (s *progressServer) Close() error {
+	close(s.done)
+	s.broadcast()
+	err := s.listener.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+	return err
+}
@@ -0,0 +1,169 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+const (
+	progressBarWidth    = 30
+	progressBarInterval = 500 * time.Millisecond
+	progressLogInterval = 30 * time.Second
+)
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// The progress bar drawn on the terminal, if any. Log lines are written
+// above it. Guarded by logMutex.
+var activeBar *progressBar
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// progressBar shows the hours completed, throughput and estimated time
+// remaining from the run stats. On a terminal it redraws a single line at
+// the bottom of standard error; otherwise it logs a progress line now and
+// then.
+type progressBar struct {
+	tty  bool
+	line string
+	done chan bool
+	stop chan bool
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Starts showing progress on standard error.
+func newProgressBar() *progressBar {
+	b := &progressBar{tty: isTerminal(os.Stderr), done: make(chan bool), stop: make(chan bool)}
+	if b.tty {
+		logMutex.Lock()
+		activeBar = b
+		logMutex.Unlock()
+		go b.run(progressBarInterval)
+	} else {
+		go b.run(progressLogInterval)
+	}
+	return b
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Updates the progress every interval until closed.
+func (b *progressBar) run(interval time.Duration) {
+	defer close(b.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.update()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Draws the bar with the latest stats, or logs them when not on a
+// terminal.
+func (b *progressBar) update() {
+	snapshot := stats.snapshot()
+	if !b.tty {
+		info("Progress: %s.", progressText(snapshot))
+		return
+	}
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	b.line = progressGraphic(snapshot) + " " + progressText(snapshot)
+	fmt.Fprintf(logOutput, "\r%s\033[K", b.line)
+}
+
+// Stops updating and leaves the final state of the bar on its own line. A
+// nil bar does nothing.
+func (b *progressBar) Close() {
+	if b == nil {
+		return
+	}
+	close(b.stop)
+	<-b.done
+	if b.tty {
+		b.update()
+		logMutex.Lock()
+		activeBar = nil
+		fmt.Fprintln(logOutput)
+		logMutex.Unlock()
+	}
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Returns the hours and events done, the rate events are being added and
+// the estimated time left, such as "12 of 744 hours, 1,234,567 events,
+// 3,412 events/s, ETA 1h2m0s".
+func progressText(snapshot *statsSnapshot) string {
+	var parts []string
+	if snapshot.HoursTotal > 0 {
+		parts = append(parts, fmt.Sprintf("%d of %d hours", snapshot.HoursDone, snapshot.HoursTotal))
+	}
+	parts = append(parts, fmt.Sprintf("%s events", formatCount(snapshot.EventsAdded)))
+
+	elapsed := time.Since(snapshot.StartTime)
+	if elapsed > 0 {
+		parts = append(parts, fmt.Sprintf("%s events/s", formatCount(int(float64(snapshot.EventsAdded)/elapsed.Seconds()))))
+	}
+	if snapshot.HoursTotal > 0 && snapshot.HoursDone > 0 {
+		remaining := elapsed / time.Duration(snapshot.HoursDone) * time.Duration(snapshot.HoursTotal-snapshot.HoursDone)
+		parts = append(parts, "ETA "+remaining.Round(time.Second).String())
+	} else if snapshot.HoursTotal > 0 {
+		parts = append(parts, "ETA unknown")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Returns a bar such as "[=======>      ]" filled in proportion to the
+// hours done.
+func progressGraphic(snapshot *statsSnapshot) string {
+	filled := 0
+	if snapshot.HoursTotal > 0 {
+		filled = progressBarWidth * snapshot.HoursDone / snapshot.HoursTotal
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return "[" + bar + "]"
+}
+
+// Returns true if a file is a terminal rather than a pipe or a file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,96 @@
+package importer
+
+import (
+	"errors"
+	"github.com/skydb/sky.go"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+var (
+	ErrNoTimestamp = errors.New("Timestamp required.")
+	ErrNoActor     = errors.New("Actor required.")
+)
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// ParseRecord returns the login of the user behind a record and the time
+// it was created, in UTC. Returns ErrNoTimestamp or ErrNoActor if the
+// record is missing either, or the error from parsing a bad timestamp.
+func ParseRecord(data map[string]interface{}) (string, time.Time, error) {
+	timestampString, ok := data["created_at"].(string)
+	if !ok {
+		return "", time.Time{}, ErrNoTimestamp
+	}
+	timestamp, err := time.Parse(time.RFC3339, timestampString)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	username, ok := ActorLogin(data)
+	if !ok {
+		return "", time.Time{}, ErrNoActor
+	}
+	return username, timestamp.UTC(), nil
+}
+
+// NewEvent builds an event from a record's schema properties, such as the
+// action from the record's type. Values that are missing or of the wrong
+// type are left off the event.
+func NewEvent(schema []*Property, data map[string]interface{}, timestamp time.Time) *sky.Event {
+	event := sky.NewEvent(timestamp, map[string]interface{}{})
+	for _, property := range schema {
+		if value, ok := property.Value(data); ok {
+			event.Data[property.Name] = value
+		}
+	}
+	return event
+}
+
+// ActorLogin returns the login of the user behind a record. Records before
+// 2015 store the login as the actor itself while later records nest it in
+// an actor object.
+func ActorLogin(data map[string]interface{}) (string, bool) {
+	switch actor := data["actor"].(type) {
+	case string:
+		return actor, len(actor) > 0
+	case map[string]interface{}:
+		login, ok := actor["login"].(string)
+		return login, ok && len(login) > 0
+	}
+	return "", false
+}
+
+// RepoName returns the "owner/name" of the repository a record is about.
+// Records before 2015 have a repository object with the owner and name, or
+// at least its URL, while later records have a repo object with the full
+// name.
+func RepoName(data map[string]interface{}) (string, bool) {
+	if repo, ok := data["repo"].(map[string]interface{}); ok {
+		if name, ok := repo["name"].(string); ok && name != "" {
+			return name, true
+		}
+	}
+	if repo, ok := data["repository"].(map[string]interface{}); ok {
+		owner, _ := repo["owner"].(string)
+		name, _ := repo["name"].(string)
+		if owner != "" && name != "" {
+			return owner + "/" + name, true
+		}
+		if u, ok := repo["url"].(string); ok {
+			if i := strings.Index(u, "github.com/"); i >= 0 {
+				return strings.Trim(u[i+len("github.com/"):], "/"), true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,33 @@
+package importer
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Ensures that a record's user and timestamp are read from either archive
+// format, and that missing ones are reported.
+func TestParseRecord(t *testing.T) {
+	var tests = []struct {
+		line      string
+		username  string
+		timestamp time.Time
+		err       error
+	}{
+		{`{"actor":"bob","created_at":"2013-01-01T00:00:00-08:00"}`, "bob", time.Date(2013, 1, 1, 8, 0, 0, 0, time.UTC), nil},
+		{`{"actor":{"login":"bob"},"created_at":"2015-01-01T08:00:00Z"}`, "bob", time.Date(2015, 1, 1, 8, 0, 0, 0, time.UTC), nil},
+		{`{"actor":{},"created_at":"2015-01-01T08:00:00Z"}`, "", time.Time{}, ErrNoActor},
+		{`{"actor":"bob"}`, "", time.Time{}, ErrNoTimestamp},
+	}
+	for _, tt := range tests {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.line), &data); err != nil {
+			t.Fatal(err)
+		}
+		username, timestamp, err := ParseRecord(data)
+		if username != tt.username || timestamp != tt.timestamp || err != tt.err {
+			t.Errorf("%s: got %q, %v, %v", tt.line, username, timestamp, err)
+		}
+	}
+}
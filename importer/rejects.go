@@ -0,0 +1,173 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// The number of records rejected so far, whether or not they're written to
+// a rejects file.
+var rejectedRecords int64
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// rejectRecord is an archive record that could not be imported, as stored
+// in a rejects file.
+type rejectRecord struct {
+	Line   string `json:"line"`
+	URL    string `json:"url,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// replayCounts holds the outcome of each record replayed from a rejects
+// file.
+type replayCounts struct {
+	imported int
+	leftOut  int
+	failing  int
+}
+
+// rejectsWriter appends records that could not be imported to a rejects
+// file. It is safe for concurrent use.
+type rejectsWriter struct {
+	mutex   sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Opens a rejects file for appending, creating it if needed.
+func newRejectsWriter(path string) (*rejectsWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	return &rejectsWriter{file: file, w: w, encoder: json.NewEncoder(w)}, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Records a line from an hour's file that was not imported. A nil writer
+// only counts it.
+func (r *rejectsWriter) write(line []byte, date time.Time, reason string) {
+	atomic.AddInt64(&rejectedRecords, 1)
+	if r == nil {
+		return
+	}
+
+	record := &rejectRecord{Line: string(bytes.TrimRight(line, "\r\n")), Reason: reason}
+	if !date.IsZero() {
+		record.URL = archiveURL(date)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.encoder.Encode(record); err != nil {
+		warn("Unable to write reject: %v", err)
+	}
+}
+
+// Flushes and closes the rejects file.
+func (r *rejectsWriter) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Runs the records in a rejects file back through the importer and reports
+// what became of each: imported this time, left out by a filter or as a
+// duplicate, or rejected again. Each record is imported as the hour whose
+// file it came from, so timestamps are clamped as they were originally and
+// records that fail again keep their source in a new rejects file.
+func replayRejectsFile(ctx context.Context, s *streamer, guard *factorGuard, path string) (replayCounts, error) {
+	var counts replayCounts
+	file, err := os.Open(path)
+	if err != nil {
+		return counts, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			record := &rejectRecord{}
+			if e := json.Unmarshal(line, record); e != nil || record.Line == "" {
+				warn("Invalid reject record: %s", line)
+			} else if e := replayRecord(ctx, s, guard, record, &counts); e != nil {
+				return counts, e
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return counts, err
+		}
+	}
+
+	info("Replayed %d rejected records: %d imported, %d left out, %d still failing.", counts.imported+counts.leftOut+counts.failing, counts.imported, counts.leftOut, counts.failing)
+	return counts, nil
+}
+
+// Imports a single rejected record and counts its outcome. The record's
+// events have been added, or have failed, by the time importRecords
+// returns, so the change in the totals is down to this record alone.
+func replayRecord(ctx context.Context, s *streamer, guard *factorGuard, record *rejectRecord, counts *replayCounts) error {
+	date, _ := bundleEntryHour(record.URL)
+	added, rejected := stats.snapshot().EventsAdded, atomic.LoadInt64(&rejectedRecords)
+	if _, err := importRecords(ctx, s, guard, nil, bytes.NewReader(append([]byte(record.Line), '\n')), date); err != nil {
+		return err
+	}
+
+	switch {
+	case atomic.LoadInt64(&rejectedRecords) > rejected:
+		counts.failing++
+	case stats.snapshot().EventsAdded > added:
+		counts.imported++
+	default:
+		counts.leftOut++
+	}
+	return nil
+}
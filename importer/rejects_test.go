@@ -0,0 +1,83 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Ensures that replayed records are imported as their source hour, that
+// records rejected again keep their source, and that each record's outcome
+// is counted.
+func TestReplayRejectsFile(t *testing.T) {
+	defer func(types map[string]bool) { allowedTypes = types }(allowedTypes)
+	allowedTypes = map[string]bool{"PushEvent": true, "WatchEvent": true}
+
+	dir, err := ioutil.TempDir("", "rejects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first, second := time.Date(2013, 1, 1, 5, 0, 0, 0, time.UTC), time.Date(2013, 1, 2, 0, 0, 0, 0, time.UTC)
+	var lines []byte
+	for _, r := range []rejectRecord{
+		{Line: `{"type":"PushEvent","actor":"benbjohnson","created_at":"2013-01-01T05:10:00Z"}`, URL: archiveURL(first), Reason: "add failed"},
+		{Line: `{"type":"PushEvent","created_at":"2013-01-01T05:20:00Z"}`, URL: archiveURL(first), Reason: "no actor"},
+		{Line: `{"type":"WatchEvent","actor":"rejected","created_at":"2013-01-02T00:30:00Z"}`, URL: archiveURL(second), Reason: "add failed"},
+		{Line: `{"type":"ForkEvent","actor":"benbjohnson","created_at":"2013-01-02T00:40:00Z"}`, URL: archiveURL(second), Reason: "add failed"},
+	} {
+		b, _ := json.Marshal(r)
+		lines = append(append(lines, b...), '\n')
+	}
+	replayPath := filepath.Join(dir, "replay.json")
+	if err := ioutil.WriteFile(replayPath, lines, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rejectsPath := filepath.Join(dir, "rejects.json")
+	if rejects, err = newRejectsWriter(rejectsPath); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { rejects = nil }()
+
+	sink := &recordingSink{events: map[string][]time.Time{}, fail: "rejected"}
+	s := newSinkStreamer([]Sink{sink})
+	counts, err := replayRejectsFile(context.Background(), s, nil, replayPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+	if err := rejects.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(sink.events["benbjohnson"]); n != 1 {
+		t.Fatalf("Unexpected events: %v", sink.events)
+	}
+	if counts != (replayCounts{imported: 1, leftOut: 1, failing: 2}) {
+		t.Fatalf("Unexpected counts: %+v", counts)
+	}
+	file, err := os.Open(rejectsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reasons := map[string]string{}
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		var r rejectRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		reasons[r.URL] = r.Reason
+	}
+	if len(reasons) != 2 || reasons[archiveURL(first)] != "no actor" || !strings.HasPrefix(reasons[archiveURL(second)], "add failed") {
+		t.Fatalf("Unexpected rejects: %v", reasons)
+	}
+}
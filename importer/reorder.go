@@ -0,0 +1,139 @@
+package importer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// reorderBuffer holds the events of hours imported at once and commits
+// them in the order of the run's hours, so Sky sees the same insertion
+// order as a run with -concurrency 1. An hour that finishes early is held
+// until every hour before it has been committed. At most window hours
+// past the oldest uncommitted one are started so a slow hour can't fill
+// memory. It is safe for concurrent use.
+type reorderBuffer struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	commits sync.Mutex
+	s       *streamer
+	dates   []time.Time
+	index   map[time.Time]int
+	events  map[time.Time]userEvents
+	done    map[int]bool
+	next    int
+	window  int
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a reorder buffer for a run over a list of hours.
+func newReorderBuffer(s *streamer, dates []time.Time, window int) *reorderBuffer {
+	r := &reorderBuffer{
+		s:      s,
+		dates:  dates,
+		index:  map[time.Time]int{},
+		events: map[time.Time]userEvents{},
+		done:   map[int]bool{},
+		window: window,
+	}
+	r.cond = sync.NewCond(&r.mutex)
+	for i, date := range dates {
+		r.index[date] = i
+	}
+	return r
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Holds an event until its hour can be committed.
+func (r *reorderBuffer) push(e *userEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events[e.hour] = append(r.events[e.hour], e)
+}
+
+// Marks an hour as finished and commits it, along with any later hours
+// that were waiting on it, once every earlier hour has been committed.
+// Returns after the hour's events have been written or handed on to the
+// hour that's holding them back. A nil buffer does nothing.
+func (r *reorderBuffer) finish(date time.Time) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	r.done[r.index[date]] = true
+	var ready []userEvents
+	for r.next < len(r.dates) && r.done[r.next] {
+		d := r.dates[r.next]
+		ready = append(ready, r.events[d])
+		delete(r.events, d)
+		delete(r.done, r.next)
+		r.next++
+	}
+
+	// Take the commit lock before letting go of the buffer so that hours
+	// released by different workers are written in order.
+	r.commits.Lock()
+	r.mutex.Unlock()
+	for _, events := range ready {
+		r.commit(events)
+	}
+	r.commits.Unlock()
+	r.cond.Broadcast()
+}
+
+// Commits the events of every held hour in order, for hours that were
+// never finished such as when the run stops early.
+func (r *reorderBuffer) flushAll() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.commits.Lock()
+	defer r.commits.Unlock()
+	for ; r.next < len(r.dates); r.next++ {
+		r.commit(r.events[r.dates[r.next]])
+		delete(r.events, r.dates[r.next])
+	}
+	r.done = map[int]bool{}
+}
+
+// Blocks until the hour at index i is within the window of the oldest
+// uncommitted hour. A nil buffer never blocks.
+func (r *reorderBuffer) wait(i int) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i >= r.next+r.window {
+		r.cond.Wait()
+	}
+}
+
+// Writes an hour's events, sorted first with -presort.
+func (r *reorderBuffer) commit(events userEvents) {
+	if config.Presort {
+		sort.Stable(events)
+	}
+	commitBatches(r.s, events, config.BatchSize)
+}
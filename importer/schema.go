@@ -0,0 +1,323 @@
+// Package importer imports GitHub Archive hours into a Sky table. It holds
+// the pipeline behind the sky-gharchive-importer command: downloading and
+// decoding archives, filtering and mapping records to events, and streaming
+// them to Sky, configured by a Config and started with an Importer's Run.
+// The schema and record parsing are exported on their own for programs that
+// read archive records themselves.
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/skydb/sky.go"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// Property maps a value in an archive record to a table property.
+// The path is a dot-separated path into the record such as
+// "repository.size". Fallback paths are tried in order when the record
+// has no value at the path. If event types are given, only records of
+// those types have the property set.
+type Property struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Transient  bool     `json:"transient"`
+	Path       string   `json:"path"`
+	Fallback   []string `json:"fallback,omitempty"`
+	EventType  string   `json:"event_type,omitempty"`
+	EventTypes []string `json:"event_types,omitempty"`
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// DataType returns the Sky data type of the property.
+func (p *Property) DataType() string {
+	return strings.ToLower(p.Type)
+}
+
+// Value returns the property's value in a record, converted to its data
+// type. Returns false if the record has no value, the value is null or
+// blank, or it can't be converted.
+func (p *Property) Value(data map[string]interface{}) (interface{}, bool) {
+	if !p.AppliesTo(data["type"]) {
+		return nil, false
+	}
+
+	value := RecordValue(data, p.Path)
+	for i := 0; value == nil && i < len(p.Fallback); i++ {
+		value = RecordValue(data, p.Fallback[i])
+	}
+	if value == nil {
+		return nil, false
+	}
+
+	switch p.DataType() {
+	case sky.Integer:
+		return ToInt(value)
+	case sky.Float:
+		return ToFloat(value)
+	case sky.Boolean:
+		b, ok := value.(bool)
+		return b, ok
+	default:
+		// Strings and factors take scalar values, leaving blank strings
+		// off so a factor never gets an empty value. Numbers are
+		// formatted by the factor guard.
+		switch v := value.(type) {
+		case string:
+			return v, v != ""
+		case map[string]interface{}, []interface{}:
+			return nil, false
+		}
+		return value, true
+	}
+}
+
+// AppliesTo returns true if the property is set on records of a type.
+func (p *Property) AppliesTo(eventType interface{}) bool {
+	if p.EventType == "" && len(p.EventTypes) == 0 {
+		return true
+	} else if p.EventType != "" && eventType == p.EventType {
+		return true
+	}
+	for _, t := range p.EventTypes {
+		if eventType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Paths returns the paths the property reads from.
+func (p *Property) Paths() []string {
+	return append([]string{p.Path}, p.Fallback...)
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// DefaultSchema returns the properties imported when no schema file is
+// given.
+func DefaultSchema() []*Property {
+	return []*Property{
+		{Name: "action", Type: sky.Factor, Transient: true, Path: "type"},
+		{Name: "language", Type: sky.Factor, Transient: true, Path: "repository.language"},
+		{Name: "forks", Type: sky.Integer, Transient: true, Path: "repository.forks"},
+		{Name: "watchers", Type: sky.Integer, Transient: true, Path: "repository.watchers"},
+		{Name: "stargazers", Type: sky.Integer, Transient: true, Path: "repository.stargazers"},
+		{Name: "size", Type: sky.Integer, Transient: true, Path: "repository.size"},
+		{Name: "commits", Type: sky.Integer, Transient: true, Path: "payload.distinct_size", Fallback: []string{"payload.size"}, EventType: "PushEvent"},
+	}
+}
+
+// TableProperties returns the table properties for a schema, starting
+// with the username that every event is stored under.
+func TableProperties(schema []*Property) []*sky.Property {
+	properties := []*sky.Property{sky.NewProperty("username", false, sky.String)}
+	for _, p := range schema {
+		properties = append(properties, sky.NewProperty(p.Name, p.Transient, p.DataType()))
+	}
+	return properties
+}
+
+// PayloadSchema returns a curated set of payload properties. Each is only
+// set on the event types known to carry it.
+func PayloadSchema() []*Property {
+	return []*Property{
+		{Name: "payload_action", Type: sky.Factor, Transient: true, Path: "payload.action", EventTypes: []string{"IssuesEvent", "IssueCommentEvent", "PullRequestEvent", "PullRequestReviewEvent", "PullRequestReviewCommentEvent", "MemberEvent", "ReleaseEvent", "WatchEvent"}},
+		{Name: "payload_ref_type", Type: sky.Factor, Transient: true, Path: "payload.ref_type", EventTypes: []string{"CreateEvent", "DeleteEvent"}},
+		{Name: "payload_merged", Type: sky.Boolean, Transient: true, Path: "payload.pull_request.merged", EventType: "PullRequestEvent"},
+		{Name: "payload_review_state", Type: sky.Factor, Transient: true, Path: "payload.review.state", EventType: "PullRequestReviewEvent"},
+	}
+}
+
+// ExtendSchema adds properties to a schema, failing if one has the name of
+// a property already in it.
+func ExtendSchema(properties []*Property, extra []*Property) ([]*Property, error) {
+	names := map[string]bool{}
+	for _, p := range properties {
+		names[p.Name] = true
+	}
+	for _, p := range extra {
+		if names[p.Name] {
+			return nil, fmt.Errorf("Invalid schema: duplicate property: %s", p.Name)
+		}
+	}
+	return append(properties, extra...), nil
+}
+
+// OverrideSchema applies overrides to a copy of a schema. An override of a
+// property in the schema changes its type and transient flag, and its path
+// if one is given. Any other override adds a property and must have a
+// path.
+func OverrideSchema(properties []*Property, overrides []*Property) ([]*Property, error) {
+	result := make([]*Property, len(properties))
+	index := map[string]int{}
+	for i, p := range properties {
+		copied := *p
+		result[i] = &copied
+		index[p.Name] = i
+	}
+
+	for _, o := range overrides {
+		i, ok := index[o.Name]
+		if !ok {
+			if o.Path == "" {
+				return nil, fmt.Errorf("Invalid property: path required for new property %s", o.Name)
+			}
+			copied := *o
+			result = append(result, &copied)
+			index[o.Name] = len(result) - 1
+			continue
+		}
+		p := result[i]
+		p.Type, p.Transient = o.Type, o.Transient
+		if o.Path != "" {
+			p.Path, p.Fallback = o.Path, nil
+		}
+	}
+	return result, nil
+}
+
+// ReadSchema reads a schema file. The file is a JSON array of properties,
+// each with a name, a Sky type (String, Factor, Integer, Float or Boolean),
+// whether it is transient and the path of its value in a record.
+func ReadSchema(path string) ([]*Property, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var properties []*Property
+	if err = json.NewDecoder(file).Decode(&properties); err != nil {
+		return nil, fmt.Errorf("Invalid schema: %v", err)
+	}
+
+	names := map[string]bool{"username": true}
+	for _, p := range properties {
+		switch {
+		case p.Name == "":
+			return nil, fmt.Errorf("Invalid schema: property name required")
+		case names[p.Name]:
+			return nil, fmt.Errorf("Invalid schema: duplicate property: %s", p.Name)
+		case p.Path == "":
+			return nil, fmt.Errorf("Invalid schema: path required for %s", p.Name)
+		}
+		switch p.DataType() {
+		case sky.String, sky.Factor, sky.Integer, sky.Float, sky.Boolean:
+		default:
+			return nil, fmt.Errorf("Invalid schema: unknown type for %s: %s", p.Name, p.Type)
+		}
+		names[p.Name] = true
+	}
+	return properties, nil
+}
+
+// RecordValue returns the value at a dot-separated path in a record. Some
+// archive versions store nested fields flat, with the whole path as the
+// key, so that is tried when the nested lookup finds nothing.
+func RecordValue(data map[string]interface{}, path string) interface{} {
+	if value := LookupPath(data, path); value != nil {
+		return value
+	}
+	return data[path]
+}
+
+// ToInt converts a decoded JSON value to an integer. Numbers, json.Number
+// values and numeric strings are accepted as long as they're whole. Returns
+// false for anything else, including nil.
+func ToInt(v interface{}) (int, bool) {
+	var f float64
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		f = v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i), true
+		}
+		var err error
+		if f, err = v.Float64(); err != nil {
+			return 0, false
+		}
+	case string:
+		if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			return i, true
+		}
+		var err error
+		if f, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	if f != math.Trunc(f) || math.IsInf(f, 0) || f > math.MaxInt64 || f < math.MinInt64 {
+		return 0, false
+	}
+	return int(f), true
+}
+
+// ToFloat converts a decoded JSON value to a floating point number. Numbers,
+// json.Number values and numeric strings are accepted.
+func ToFloat(v interface{}) (float64, bool) {
+	var f float64
+	switch v := v.(type) {
+	case int:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	case float64:
+		f = v
+	case json.Number:
+		var err error
+		if f, err = v.Float64(); err != nil {
+			return 0, false
+		}
+	case string:
+		var err error
+		if f, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// LookupPath returns the value at a dot-separated path in a record, or nil.
+func LookupPath(data map[string]interface{}, path string) interface{} {
+	var value interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
@@ -0,0 +1,78 @@
+package importer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// Closed when the current run is asked to stop, with the reason given.
+var stopMutex sync.Mutex
+var stopChannel = make(chan bool)
+var stopReason string
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Asks the import to stop once the current hour has finished. Only the
+// first request is recorded.
+func requestStop(reason string) {
+	stopMutex.Lock()
+	defer stopMutex.Unlock()
+	select {
+	case <-stopChannel:
+	default:
+		stopReason = reason
+		close(stopChannel)
+	}
+}
+
+// Returns true if the import has been asked to stop.
+func stopRequested() bool {
+	stopMutex.Lock()
+	defer stopMutex.Unlock()
+	select {
+	case <-stopChannel:
+		return true
+	default:
+		return false
+	}
+}
+
+// Clears a stop requested by an earlier run.
+func resetStop() {
+	stopMutex.Lock()
+	defer stopMutex.Unlock()
+	stopChannel = make(chan bool)
+	stopReason = ""
+}
+
+// Asks the import to stop after it has been running for a given duration.
+// The returned timer cancels the request if stopped first.
+func stopAfter(d time.Duration) *time.Timer {
+	return time.AfterFunc(d, func() {
+		requestStop("maximum run time of " + d.String() + " reached")
+	})
+}
+
+// Waits for a duration unless the context is cancelled first. Returns
+// false if it was cancelled.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
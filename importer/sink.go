@@ -0,0 +1,140 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/skydb/sky.go"
+	"sync/atomic"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// Sink is a destination for imported events. Each stream worker writes to
+// its own sink, so a sink only needs to be safe for concurrent use if it
+// is shared between workers.
+type Sink interface {
+	// Writes a single event for a user.
+	AddEvent(username string, e *sky.Event) error
+
+	// Writes anything buffered. Called when the worker stops.
+	Flush() error
+}
+
+// skySink adds events to a Sky table over its own connection.
+type skySink struct {
+	ctx    context.Context
+	client *sky.Client
+	table  *sky.Table
+}
+
+// nullSink discards events, for a dry run.
+type nullSink struct{}
+
+// jsonlSink writes events to the -output file or directory.
+type jsonlSink struct {
+	output eventOutput
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Connects to the server and finds the table. Cancelling the context stops
+// the sink from waiting to reconnect.
+func newSkySink(ctx context.Context) (*skySink, error) {
+	client := sky.NewClient(config.Host)
+	client.Port = config.Port
+	if !client.Ping() {
+		return nil, errors.New("server is not running")
+	}
+	table, err := client.GetTable(config.TableName)
+	if err != nil || table == nil {
+		return nil, fmt.Errorf("table not found: %v", err)
+	}
+	return &skySink{ctx, client, table}, nil
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Adds an event to the table. If the connection to the server fails the
+// sink reconnects and retries the write, up to -reconnect-retries times
+// with the delay doubling from -reconnect-delay. Past that the connection
+// is given up for every sink and the run is stopped.
+func (s *skySink) AddEvent(username string, e *sky.Event) error {
+	if connectionLost() {
+		return errConnectionLost
+	}
+	err := s.table.AddEvent(username, e, sky.Merge)
+	for attempt := 1; err != nil && isConnectionError(err); attempt++ {
+		if attempt > config.ReconnectRetries {
+			if atomic.CompareAndSwapInt32(&lostConnection, 0, 1) {
+				logError("Unable to reconnect to Sky after %d attempts: %v", config.ReconnectRetries, err)
+				requestStop("lost connection to Sky")
+			}
+			return errConnectionLost
+		}
+		delay := config.ReconnectDelay << uint(attempt-1)
+		warn("Lost connection to Sky (%v), reconnecting in %v (%d/%d).", err, delay, attempt, config.ReconnectRetries)
+		if !sleepContext(s.ctx, delay) {
+			break
+		}
+		if !s.reconnect() {
+			continue
+		}
+		err = s.table.AddEvent(username, e, sky.Merge)
+	}
+	return err
+}
+
+// Events are written as they're added so there's nothing to flush.
+func (s *skySink) Flush() error {
+	return nil
+}
+
+// Waits for the server to answer a ping and fetches the table again so
+// the sink picks up a restarted server. With -skip-ping-on-reconnect the
+// write is simply retried.
+func (s *skySink) reconnect() bool {
+	if config.SkipPingOnReconnect {
+		return true
+	}
+	if !s.client.Ping() {
+		return false
+	}
+	table, err := s.client.GetTable(config.TableName)
+	if err != nil || table == nil {
+		return false
+	}
+	s.table = table
+	return true
+}
+
+// Discards the event.
+func (nullSink) AddEvent(username string, e *sky.Event) error {
+	return nil
+}
+
+func (nullSink) Flush() error {
+	return nil
+}
+
+// Writes the event to the output.
+func (s *jsonlSink) AddEvent(username string, e *sky.Event) error {
+	return s.output.write(&userEvent{username: username, event: e})
+}
+
+// The output is shared by every worker and flushed when it is closed.
+func (s *jsonlSink) Flush() error {
+	return nil
+}
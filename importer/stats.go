@@ -0,0 +1,467 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// runStats holds the counters for the current run. It is safe for
+// concurrent use.
+type runStats struct {
+	mutex         sync.Mutex
+	startTime     time.Time
+	hoursTotal    int
+	hoursDone     int
+	hoursTimedOut int
+	hoursFailed   int
+	failedHours   []time.Time
+	skippedHours  []time.Time
+	currentHours  map[time.Time]time.Time
+	hours         map[time.Time]*hourStats
+	hourDurations histogram
+	eventsRead    int
+	eventsAdded   int
+	eventsSkipped int
+	skipReasons   map[string]int
+	eventsClamped int
+	missingFields map[string]int
+	parseRecords  int
+	parseTime     time.Duration
+	streamEvents  int
+	streamTime    time.Duration
+}
+
+// hourStats holds the counters for a single archive hour.
+type hourStats struct {
+	read    int
+	added   int
+	skipped map[string]int
+}
+
+// histogram counts observations into buckets by upper bound, as exposed
+// to Prometheus.
+type histogram struct {
+	counts []int
+	count  int
+	sum    float64
+}
+
+// statsSnapshot is a point-in-time copy of the run counters. It is the
+// payload for status reporting.
+type statsSnapshot struct {
+	StartTime     time.Time      `json:"start_time"`
+	Elapsed       string         `json:"elapsed"`
+	HoursTotal    int            `json:"hours_total"`
+	HoursDone     int            `json:"hours_done"`
+	HoursTimedOut int            `json:"hours_timed_out,omitempty"`
+	HoursFailed   int            `json:"hours_failed,omitempty"`
+	CurrentHour   string         `json:"current_hour,omitempty"`
+	EventsRead    int            `json:"events_read"`
+	EventsAdded   int            `json:"events_added"`
+	EventsSkipped int            `json:"events_skipped"`
+	SkipReasons   map[string]int `json:"skip_reasons,omitempty"`
+	EventsClamped int            `json:"events_clamped,omitempty"`
+	MissingFields map[string]int `json:"missing_fields,omitempty"`
+	Parse         stageSnapshot  `json:"parse"`
+	Stream        stageSnapshot  `json:"stream"`
+}
+
+// stageSnapshot describes the work done by one stage of the pipeline.
+// Latency is the average time spent on an item and utilization is the
+// fraction of the stage's workers' time spent busy. A stage close to full
+// utilization is the bottleneck.
+type stageSnapshot struct {
+	Workers     int     `json:"workers"`
+	Items       int     `json:"items"`
+	Rate        float64 `json:"rate"`
+	Latency     string  `json:"latency"`
+	Utilization float64 `json:"utilization"`
+}
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// The counters for the current run.
+var stats = &runStats{startTime: time.Now()}
+
+// The upper bounds, in seconds, of the hour duration histogram buckets.
+var hourDurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Records the number of hours the run will cover.
+func (s *runStats) begin(hours int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.startTime = time.Now()
+	s.hoursTotal = hours
+}
+
+// Records an hour that has started importing.
+func (s *runStats) startHour(date time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.currentHours == nil {
+		s.currentHours = map[time.Time]time.Time{}
+	}
+	s.currentHours[date] = time.Now()
+}
+
+// Records that an hour has finished, successfully or not, and how long it
+// took to download and import.
+func (s *runStats) finishHour(date time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hoursDone++
+	if start, ok := s.currentHours[date]; ok {
+		s.hourDurations.observe(time.Since(start).Seconds())
+	}
+	delete(s.currentHours, date)
+}
+
+// Records an hour that was abandoned for taking longer than -hour-timeout.
+func (s *runStats) timedOut() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hoursTimedOut++
+}
+
+// Records an hour that failed to import.
+func (s *runStats) hourFailed() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hoursFailed++
+}
+
+// Records an hour the manifest marks as failed or skipped.
+func (s *runStats) hourOutcome(date time.Time, status string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch status {
+	case hourFailed:
+		s.failedHours = append(s.failedHours, date)
+	case hourSkipped:
+		s.skippedHours = append(s.skippedHours, date)
+	}
+}
+
+// Returns the hours that failed and were skipped, in order.
+func (s *runStats) incompleteHours() ([]time.Time, []time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return sortedHours(s.failedHours), sortedHours(s.skippedHours)
+}
+
+// Returns the earliest hour being imported, or a zero time if there is
+// none. Several hours may be in progress at once. The mutex must be held.
+func (s *runStats) currentHour() time.Time {
+	var current time.Time
+	for date := range s.currentHours {
+		if current.IsZero() || date.Before(current) {
+			current = date
+		}
+	}
+	return current
+}
+
+// Returns the counters for an hour, or nil for records that didn't come
+// from an archive hour. The mutex must be held.
+func (s *runStats) hour(date time.Time) *hourStats {
+	if date.IsZero() {
+		return nil
+	}
+	if s.hours == nil {
+		s.hours = map[time.Time]*hourStats{}
+	}
+	h := s.hours[date]
+	if h == nil {
+		h = &hourStats{skipped: map[string]int{}}
+		s.hours[date] = h
+	}
+	return h
+}
+
+// Records a record read from an hour's file.
+func (s *runStats) read(date time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.eventsRead++
+	if h := s.hour(date); h != nil {
+		h.read++
+	}
+}
+
+// Records an event from an hour added to the table.
+func (s *runStats) added(date time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.eventsAdded++
+	if h := s.hour(date); h != nil {
+		h.added++
+	}
+}
+
+// Records a record from an hour that was not imported and why.
+func (s *runStats) skipped(date time.Time, reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.skipReasons == nil {
+		s.skipReasons = map[string]int{}
+	}
+	s.skipReasons[reason]++
+	s.eventsSkipped++
+	if h := s.hour(date); h != nil {
+		h.skipped[reason]++
+	}
+}
+
+// Records an event whose timestamp was moved into its file's hour.
+func (s *runStats) clamped() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.eventsClamped++
+}
+
+// Records an event from an hour dropped for missing a required property.
+func (s *runStats) missingField(date time.Time, name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.missingFields == nil {
+		s.missingFields = map[string]int{}
+	}
+	s.missingFields[name]++
+	s.eventsSkipped++
+	if h := s.hour(date); h != nil {
+		h.skipped["missing "+name]++
+	}
+}
+
+// Records records decoded by a parse worker and the time it took.
+func (s *runStats) parsed(n int, d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.parseRecords += n
+	s.parseTime += d
+}
+
+// Records the time taken to write an event to Sky.
+func (s *runStats) streamed(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.streamEvents++
+	s.streamTime += d
+}
+
+// Returns a copy of the current counters.
+func (s *runStats) snapshot() *statsSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	elapsed := time.Since(s.startTime)
+	snapshot := &statsSnapshot{
+		StartTime:     s.startTime,
+		Elapsed:       elapsed.String(),
+		HoursTotal:    s.hoursTotal,
+		HoursDone:     s.hoursDone,
+		HoursTimedOut: s.hoursTimedOut,
+		HoursFailed:   s.hoursFailed,
+		EventsRead:    s.eventsRead,
+		EventsAdded:   s.eventsAdded,
+		EventsSkipped: s.eventsSkipped,
+		EventsClamped: s.eventsClamped,
+		Parse:         newStageSnapshot(config.ParseWorkers, s.parseRecords, s.parseTime, elapsed),
+		Stream:        newStageSnapshot(config.StreamWorkers, s.streamEvents, s.streamTime, elapsed),
+	}
+	if len(s.skipReasons) > 0 {
+		snapshot.SkipReasons = map[string]int{}
+		for reason, n := range s.skipReasons {
+			snapshot.SkipReasons[reason] = n
+		}
+	}
+	if len(s.missingFields) > 0 {
+		snapshot.MissingFields = map[string]int{}
+		for name, n := range s.missingFields {
+			snapshot.MissingFields[name] = n
+		}
+	}
+
+	if current := s.currentHour(); !current.IsZero() {
+		snapshot.CurrentHour = current.Format(time.RFC3339)
+	}
+	return snapshot
+}
+
+// Writes a table of the records read, added and skipped for each reason,
+// for every hour and for the whole run.
+func (s *runStats) writeSummary(w io.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	total := &hourStats{read: s.eventsRead, added: s.eventsAdded, skipped: map[string]int{}}
+	for reason, n := range s.skipReasons {
+		total.skipped[reason] = n
+	}
+	for name, n := range s.missingFields {
+		total.skipped["missing "+name] = n
+	}
+
+	var reasons []string
+	for reason := range total.skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	var dates []time.Time
+	for date := range s.hours {
+		dates = append(dates, date)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "HOUR\tREAD\tADDED\tSKIPPED\t")
+	for _, reason := range reasons {
+		fmt.Fprintf(tw, "%s\t", reason)
+	}
+	fmt.Fprintln(tw)
+
+	row := func(label string, h *hourStats) {
+		skipped := 0
+		for _, n := range h.skipped {
+			skipped += n
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t", label, h.read, h.added, skipped)
+		for _, reason := range reasons {
+			fmt.Fprintf(tw, "%d\t", h.skipped[reason])
+		}
+		fmt.Fprintln(tw)
+	}
+	for _, date := range dates {
+		row(date.Format(time.RFC3339), s.hours[date])
+	}
+	row("TOTAL", total)
+	tw.Flush()
+}
+
+// Adds an observation to the bucket for its value.
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]int, len(hourDurationBuckets))
+	}
+	for i, bound := range hourDurationBuckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Returns the number of skipped records for each reason, most frequent
+// first, such as "89 (bad timestamp), 12 (no actor)".
+func (s *statsSnapshot) skipSummary() string {
+	counts := map[string]int{}
+	for reason, n := range s.SkipReasons {
+		counts[reason] = n
+	}
+	for name, n := range s.MissingFields {
+		counts["missing "+name] = n
+	}
+	if len(counts) == 0 {
+		return "0"
+	}
+
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if counts[reasons[i]] != counts[reasons[j]] {
+			return counts[reasons[i]] > counts[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		parts[i] = fmt.Sprintf("%s (%s)", formatCount(counts[reason]), reason)
+	}
+	return strings.Join(parts, ", ")
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Formats a count with thousands separators.
+func formatCount(n int) string {
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	str := strconv.Itoa(n)
+	for i := len(str) - 3; i > 0; i -= 3 {
+		str = str[:i] + "," + str[i:]
+	}
+	return str
+}
+
+// Returns a sorted copy of a list of hours.
+func sortedHours(dates []time.Time) []time.Time {
+	sorted := append([]time.Time{}, dates...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	return sorted
+}
+
+func newStageSnapshot(workers int, items int, busy time.Duration, elapsed time.Duration) stageSnapshot {
+	snapshot := stageSnapshot{Workers: workers, Items: items, Latency: "0s"}
+	if elapsed > 0 {
+		snapshot.Rate = float64(items) / elapsed.Seconds()
+		if workers > 0 {
+			snapshot.Utilization = busy.Seconds() / (elapsed.Seconds() * float64(workers))
+		}
+	}
+	if items > 0 {
+		snapshot.Latency = (busy / time.Duration(items)).String()
+	}
+	return snapshot
+}
+
+// Logs the throughput of each stage every interval until the context is
+// cancelled.
+func logStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		snapshot := stats.snapshot()
+		info("parse: %d records, %.0f/s, %s/record, %.0f%% busy (%d workers) | stream: %d events, %.0f/s, %s/event, %.0f%% busy (%d workers)",
+			snapshot.Parse.Items, snapshot.Parse.Rate, snapshot.Parse.Latency, snapshot.Parse.Utilization*100, snapshot.Parse.Workers,
+			snapshot.Stream.Items, snapshot.Stream.Rate, snapshot.Stream.Latency, snapshot.Stream.Utilization*100, snapshot.Stream.Workers)
+	}
+}
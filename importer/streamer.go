@@ -0,0 +1,242 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+// Returned when reading stops because -limit events have been added.
+var errLimitReached = errors.New("Event limit reached.")
+
+// Returned once a worker has given up reconnecting to the server.
+var errConnectionLost = errors.New("Lost connection to Sky.")
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// The number of events added or being added toward -limit.
+var limitUsed int64
+
+// Set once a worker has given up reconnecting to the server.
+var lostConnection int32
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// streamer adds events to Sky from a pool of workers. Each worker owns its
+// own sink, such as a client and table, so that writes don't serialize on
+// a shared connection. Events are routed to workers by username so that every
+// user's timeline is written in order by a single worker.
+//
+// Several producers may add events at once. Each producer tracks its own
+// events with a wait group rather than waiting on the whole streamer.
+type streamer struct {
+	workers []*streamWorker
+	done    sync.WaitGroup
+}
+
+// streamWorker writes the events for its share of users.
+type streamWorker struct {
+	sink Sink
+	c    chan *streamItem
+}
+
+// streamItem is a queued event and the wait group of the producer that
+// queued it, if any.
+type streamItem struct {
+	e    *userEvent
+	done *sync.WaitGroup
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Connects n workers to the server, each with its own client. Every client
+// must answer a ping and find the table or the streamer is not started.
+// Cancelling the context stops workers from waiting to reconnect but they
+// still write every queued event they can. In a dry run the workers don't
+// connect and events are counted without being written, and with -output
+// they write to the output instead of connecting.
+func newStreamer(ctx context.Context, n int) (*streamer, error) {
+	var sinks []Sink
+	var failed []string
+	for i := 0; i < n; i++ {
+		if config.DryRun {
+			sinks = append(sinks, nullSink{})
+			continue
+		} else if output != nil {
+			sinks = append(sinks, &jsonlSink{output})
+			continue
+		}
+
+		sink, err := newSkySink(ctx)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("worker %d: %v", i, err))
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("Unable to connect %d of %d stream workers:\n%s", len(failed), n, strings.Join(failed, "\n"))
+	}
+	return newSinkStreamer(sinks), nil
+}
+
+// Starts a worker for each sink.
+func newSinkStreamer(sinks []Sink) *streamer {
+	s := &streamer{}
+	for _, sink := range sinks {
+		w := &streamWorker{sink, make(chan *streamItem, config.StreamBuffer)}
+		s.workers = append(s.workers, w)
+		s.done.Add(1)
+		go s.run(w)
+	}
+	return s
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Queues an event on the worker that owns its user. If a wait group is
+// given it is marked done once the event has been written.
+func (s *streamer) add(e *userEvent, done *sync.WaitGroup) {
+	h := fnv.New32a()
+	h.Write([]byte(e.username))
+	if done != nil {
+		done.Add(1)
+	}
+	s.workers[int(h.Sum32()%uint32(len(s.workers)))].c <- &streamItem{e, done}
+}
+
+// Writes any queued events and stops the workers. Every producer must have
+// finished adding events first.
+func (s *streamer) close() {
+	for _, w := range s.workers {
+		close(w.c)
+	}
+	s.done.Wait()
+}
+
+// Writes events for a worker until its queue is closed, then flushes its
+// sink.
+func (s *streamer) run(w *streamWorker) {
+	defer s.done.Done()
+	for item := range w.c {
+		w.add(item.e)
+		if item.done != nil {
+			item.done.Done()
+		}
+	}
+	if err := w.sink.Flush(); err != nil {
+		warn("Unable to flush events: %v", err)
+	}
+}
+
+// Adds a single event to the worker's sink and, when dual writing, to the
+// event file.
+func (w *streamWorker) add(e *userEvent) {
+	if !reserveEvent() {
+		return
+	}
+
+	t := time.Now()
+	err := w.sink.AddEvent(e.username, e.event)
+	toSky := (err == nil)
+	stats.streamed(time.Since(t))
+	if toSky {
+		stats.added(e.hour)
+		if config.EventLimit > 0 && atomic.LoadInt64(&limitUsed) >= int64(config.EventLimit) {
+			requestStop(fmt.Sprintf("limit of %d events reached", config.EventLimit))
+		}
+	} else {
+		atomic.AddInt64(&limitUsed, -1)
+		logAddFailure(e, err)
+		stats.skipped(e.hour, "add failed")
+		rejects.write(e.line, e.hour, "add failed: "+err.Error())
+	}
+
+	if dualWriter != nil {
+		err := dualWriter.write(e)
+		if err != nil {
+			warn("Unable to write event: %v", err)
+		}
+		dualCounts.record(e.event.Timestamp, toSky, err == nil)
+	}
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Logs an event that couldn't be added with the error from the server,
+// distinguishing a lost connection from the server rejecting the event,
+// such as for a property type mismatch.
+func logAddFailure(e *userEvent, err error) {
+	fields := logFields{"username": e.username, "timestamp": e.event.Timestamp.UTC().Format(time.RFC3339), "error": err}
+	if !e.hour.IsZero() {
+		fields["url"] = archiveURL(e.hour)
+	}
+	if isConnectionError(err) {
+		fields.warn("Unable to add event: connection failed.")
+	} else {
+		fields.warn("Unable to add event: rejected by server.")
+	}
+}
+
+// Reserves one of the -limit events for a write. Returns false once every
+// event has been reserved.
+func reserveEvent() bool {
+	if config.EventLimit <= 0 {
+		return true
+	}
+	if atomic.AddInt64(&limitUsed, 1) > int64(config.EventLimit) {
+		atomic.AddInt64(&limitUsed, -1)
+		return false
+	}
+	return true
+}
+
+// Returns true once -limit events have been added or are being added.
+func limitReached() bool {
+	return config.EventLimit > 0 && atomic.LoadInt64(&limitUsed) >= int64(config.EventLimit)
+}
+
+// Returns true once a worker has given up reconnecting to the server.
+func connectionLost() bool {
+	return atomic.LoadInt32(&lostConnection) == 1
+}
+
+// Returns true if an error came from the connection to the server rather
+// than from the server rejecting a request.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,80 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/skydb/sky.go"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink remembers the events written to it.
+type recordingSink struct {
+	mutex   sync.Mutex
+	events  map[string][]time.Time
+	fail    string
+	flushed bool
+}
+
+func (s *recordingSink) AddEvent(username string, e *sky.Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if username == s.fail {
+		return errors.New("rejected")
+	}
+	s.events[username] = append(s.events[username], e.Timestamp)
+	return nil
+}
+
+func (s *recordingSink) Flush() error {
+	s.flushed = true
+	return nil
+}
+
+// Ensures that every event reaches a sink with each user's events in
+// order and that failures are counted.
+func TestStreamerSinks(t *testing.T) {
+	var sinks []Sink
+	for i := 0; i < 3; i++ {
+		sinks = append(sinks, &recordingSink{events: map[string][]time.Time{}, fail: "rejected"})
+	}
+	before := stats.snapshot()
+
+	s := newSinkStreamer(sinks)
+	var done sync.WaitGroup
+	start := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		username := fmt.Sprintf("user%d", i%10)
+		s.add(&userEvent{username: username, event: sky.NewEvent(start.Add(time.Duration(i)*time.Second), nil)}, &done)
+	}
+	s.add(&userEvent{username: "rejected", event: sky.NewEvent(start, nil)}, &done)
+	done.Wait()
+	s.close()
+
+	total := 0
+	for _, sink := range sinks {
+		r := sink.(*recordingSink)
+		if !r.flushed {
+			t.Error("Sink not flushed.")
+		}
+		for username, timestamps := range r.events {
+			total += len(timestamps)
+			for i := 1; i < len(timestamps); i++ {
+				if timestamps[i].Before(timestamps[i-1]) {
+					t.Errorf("%s: events out of order", username)
+				}
+			}
+		}
+	}
+	if total != 100 {
+		t.Fatalf("Unexpected event count: %d", total)
+	}
+
+	after := stats.snapshot()
+	if n := after.EventsAdded - before.EventsAdded; n != 100 {
+		t.Fatalf("Unexpected added count: %d", n)
+	} else if n := after.SkipReasons["add failed"] - before.SkipReasons["add failed"]; n != 1 {
+		t.Fatalf("Unexpected failed count: %d", n)
+	}
+}
@@ -0,0 +1,125 @@
+package importer
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+var errUnmappedField = errors.New("Record has an unmapped field.")
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// strictChecker looks for non-null record fields that aren't mapped to a
+// property. By default every top-level field is checked; a list of paths
+// can be given to check those instead.
+type strictChecker struct {
+	mutex  sync.Mutex
+	fail   bool
+	paths  []string
+	counts map[string]int
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a checker. In "error" mode the first unmapped field is an error,
+// in "warn" mode they are counted and reported at the end.
+func newStrictChecker(mode string, paths []string) *strictChecker {
+	c := &strictChecker{fail: (mode == "error"), counts: map[string]int{}}
+	for _, path := range paths {
+		c.paths = append(c.paths, strings.TrimSpace(path))
+	}
+	return c
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Checks a record for unmapped non-null fields. Returns errUnmappedField
+// if one is found in error mode. A nil checker does nothing.
+func (c *strictChecker) check(data map[string]interface{}, lineNumber int) error {
+	if c == nil {
+		return nil
+	}
+
+	var unmapped []string
+	if c.paths == nil {
+		for key, value := range data {
+			if value != nil && !isMappedTopLevel(key) {
+				unmapped = append(unmapped, key)
+			}
+		}
+	} else {
+		for _, path := range c.paths {
+			if LookupPath(data, path) != nil && !isMappedPath(path) {
+				unmapped = append(unmapped, path)
+			}
+		}
+	}
+	if len(unmapped) == 0 {
+		return nil
+	}
+
+	sort.Strings(unmapped)
+	if c.fail {
+		logFields{"line": lineNumber}.warn("Unmapped fields: %s", strings.Join(unmapped, ", "))
+		return errUnmappedField
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, path := range unmapped {
+		c.counts[path]++
+	}
+	return nil
+}
+
+// Reports how many records had each unmapped field.
+func (c *strictChecker) report() {
+	if c == nil || len(c.counts) == 0 {
+		return
+	}
+
+	var paths []string
+	for path := range c.counts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		warn("Unmapped field %s had a value in %d records.", path, c.counts[path])
+	}
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Returns true if a top-level field is read, in whole or in part.
+func isMappedTopLevel(key string) bool {
+	for _, mapped := range mappedPaths() {
+		if mapped == key || strings.HasPrefix(mapped, key+".") {
+			return true
+		}
+	}
+	return false
+}
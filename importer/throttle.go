@@ -0,0 +1,163 @@
+package importer
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The most bytes read from a throttled download at once, so that the
+// pacing stays smooth instead of alternating long reads and long waits.
+const maxThrottledRead = 32 * 1024
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// Paces archive downloads when -max-bandwidth is set.
+var bandwidth *bandwidthLimiter
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// bandwidthLimiter paces reads so the bytes read across every download
+// average no more than a rate. It is safe for concurrent use.
+type bandwidthLimiter struct {
+	mutex sync.Mutex
+	rate  float64
+	next  time.Time
+}
+
+// throttledBody is a download body whose reads are paced by the limiter.
+type throttledBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *bandwidthLimiter
+}
+
+// timedBody is a response body that is closed if a single read takes
+// longer than the timeout.
+type timedBody struct {
+	io.ReadCloser
+	timeout time.Duration
+}
+
+// readTimeoutError is returned by a timedBody read that timed out. It is a
+// net.Error so that it's reported like any other timeout.
+type readTimeoutError struct{}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a limiter for a rate in bytes per second.
+func newBandwidthLimiter(rate uint64) *bandwidthLimiter {
+	return &bandwidthLimiter{rate: float64(rate)}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Accounts for n bytes that were read and waits until reading them fits
+// the rate. Time left unused while nothing is read isn't saved up, so a
+// burst after an idle period is paced like any other. Returns the
+// context's error if it's cancelled while waiting.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mutex.Unlock()
+
+	if delay > 0 && !sleepContext(ctx, delay) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Reads up to maxThrottledRead bytes and waits for them to fit the rate.
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > maxThrottledRead {
+		p = p[:maxThrottledRead]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.wait(b.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// Reads from the body, closing it to end the read if no data arrives
+// within the timeout.
+func (b *timedBody) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(b.timeout, func() { b.ReadCloser.Close() })
+	n, err := b.ReadCloser.Read(p)
+	if !timer.Stop() {
+		err = readTimeoutError{}
+	}
+	return n, err
+}
+
+func (readTimeoutError) Error() string   { return "Timed out waiting for data." }
+func (readTimeoutError) Timeout() bool   { return true }
+func (readTimeoutError) Temporary() bool { return true }
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Returns a download body paced by -max-bandwidth, or the body itself if
+// downloads aren't throttled.
+func throttle(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	if bandwidth == nil {
+		return body
+	}
+	return &throttledBody{ReadCloser: body, ctx: ctx, limiter: bandwidth}
+}
+
+// Returns a response body whose reads each time out after -http-timeout
+// when downloads are throttled. The client's timeout then only covers
+// the response headers, since a throttled body takes as long to read as
+// the rate requires, so this still catches a download that stalls.
+func readTimeout(body io.ReadCloser) io.ReadCloser {
+	if bandwidth == nil || config.HTTPTimeout <= 0 {
+		return body
+	}
+	return &timedBody{ReadCloser: body, timeout: config.HTTPTimeout}
+}
+
+// Parses a bandwidth such as "5MB/s", "512KB" or "1048576" into bytes per
+// second. The "/s" is optional and units are powers of 1024, as for
+// -max-memory.
+func parseBandwidth(s string) (uint64, error) {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasSuffix(strings.ToLower(trimmed), "/s") {
+		trimmed = trimmed[:len(trimmed)-2]
+	}
+	return parseByteSize(trimmed)
+}
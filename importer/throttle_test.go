@@ -0,0 +1,87 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Ensures that bandwidths are parsed with or without "/s".
+func TestParseBandwidth(t *testing.T) {
+	var tests = []struct {
+		s    string
+		rate uint64
+		err  bool
+	}{
+		{"5MB/s", 5 * 1024 * 1024, false},
+		{"512kb/S", 512 * 1024, false},
+		{"1048576", 1048576, false},
+		{"0/s", 0, true},
+		{"0.5", 0, true},
+		{"fast", 0, true},
+	}
+	for _, tt := range tests {
+		rate, err := parseBandwidth(tt.s)
+		if (err != nil) != tt.err || rate != tt.rate {
+			t.Errorf("%s: got %d, %v", tt.s, rate, err)
+		}
+	}
+}
+
+// Ensures that downloads read at once share the limiter's rate.
+func TestThrottledBody(t *testing.T) {
+	limiter := newBandwidthLimiter(1024 * 1024)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := &throttledBody{ioutil.NopCloser(bytes.NewReader(make([]byte, 64*1024))), context.Background(), limiter}
+			if n, err := io.Copy(ioutil.Discard, body); n != 64*1024 || err != nil {
+				t.Errorf("read %d: %v", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 128KB at 1MB/s takes 125ms.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("unexpected duration: %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := &throttledBody{ioutil.NopCloser(bytes.NewReader(make([]byte, 1024*1024))), ctx, limiter}
+	if _, err := io.Copy(ioutil.Discard, body); err != context.Canceled {
+		t.Errorf("expected the read to be cancelled, got %v", err)
+	}
+}
+
+// Ensures that a read of a throttled download times out when no data
+// arrives, and that the client then only times the response headers.
+func TestReadTimeout(t *testing.T) {
+	defer func(b *bandwidthLimiter, d time.Duration) { bandwidth, config.HTTPTimeout = b, d }(bandwidth, config.HTTPTimeout)
+	bandwidth, config.HTTPTimeout = newBandwidthLimiter(1024), 20*time.Millisecond
+
+	r, w := io.Pipe()
+	defer w.Close()
+	go w.Write([]byte("data"))
+	body := readTimeout(r)
+	if n, err := body.Read(make([]byte, 4)); n != 4 || err != nil {
+		t.Fatalf("read %d: %v", n, err)
+	}
+	if _, err := body.Read(make([]byte, 4)); !isTimeout(err) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	client := newHTTPClient()
+	if client.Timeout != 0 || client.Transport.(*http.Transport).ResponseHeaderTimeout != config.HTTPTimeout {
+		t.Errorf("unexpected timeouts: %v, %v", client.Timeout, client.Transport.(*http.Transport).ResponseHeaderTimeout)
+	}
+}
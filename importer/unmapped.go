@@ -0,0 +1,141 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The maximum length of an example value in the unmapped field report.
+const maxExampleLength = 60
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// unmappedField tracks how often an unmapped leaf path occurs in the
+// archive along with the first value seen for it.
+type unmappedField struct {
+	path    string
+	count   int
+	example string
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Reads up to sample records across a set of hours and prints every leaf
+// path that is not read by the importer, most frequent first.
+func dumpUnmappedFields(ctx context.Context, dates []time.Time, sample int) error {
+	fields := map[string]*unmappedField{}
+	records := 0
+
+	for i := 0; i < len(dates) && (sample <= 0 || records < sample) && ctx.Err() == nil; i++ {
+		archive, err := openArchive(ctx, dates[i])
+		if err != nil {
+			warn("Invalid file: %v", err)
+			continue
+		}
+
+		r := bufio.NewReader(archive)
+		for sample <= 0 || records < sample {
+			line, err := r.ReadBytes('\n')
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				archive.Close()
+				return err
+			}
+
+			data := map[string]interface{}{}
+			if err = json.Unmarshal(line, &data); err != nil {
+				continue
+			}
+			records++
+			collectUnmappedFields(fields, "", data)
+		}
+		archive.Close()
+	}
+
+	// Print the most frequent fields first.
+	list := make([]*unmappedField, 0, len(fields))
+	for _, field := range fields {
+		list = append(list, field)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].count != list[j].count {
+			return list[i].count > list[j].count
+		}
+		return list[i].path < list[j].path
+	})
+	for _, field := range list {
+		fmt.Printf("%d\t%s\t%s\n", field.count, field.path, field.example)
+	}
+
+	info("%d unmapped fields found in %d records.", len(list), records)
+	return nil
+}
+
+// Walks a decoded record and counts every leaf path that isn't mapped.
+// Array elements share a path segment of "[]".
+func collectUnmappedFields(fields map[string]*unmappedField, path string, value interface{}) {
+	if path != "" && isMappedPath(path) {
+		return
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if path == "" {
+				collectUnmappedFields(fields, key, child)
+			} else {
+				collectUnmappedFields(fields, path+"."+key, child)
+			}
+		}
+		return
+	case []interface{}:
+		if len(value) > 0 {
+			for _, child := range value {
+				collectUnmappedFields(fields, path+"[]", child)
+			}
+			return
+		}
+	}
+
+	field := fields[path]
+	if field == nil {
+		example, _ := json.Marshal(value)
+		if len(example) > maxExampleLength {
+			example = append(example[:maxExampleLength], "..."...)
+		}
+		field = &unmappedField{path: path, example: string(example)}
+		fields[path] = field
+	}
+	field.count++
+}
+
+// Returns true if a path, or one of its parents, is read by the importer.
+func isMappedPath(path string) bool {
+	for _, mapped := range mappedPaths() {
+		if path == mapped || strings.HasPrefix(path, mapped+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package importer
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+//
+// Errors
+//
+//------------------------------------------------------------------------------
+
+// Returned when an archive file doesn't match its -checksums entry.
+var errChecksumMismatch = errors.New("Checksum mismatch.")
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+// The expected SHA-256 checksums of archive files from -checksums, by file
+// name.
+var checksums map[string]string
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Reads a checksums file in the format written by sha256sum: a hex
+// checksum and a file name on each line. Only the base of the name is
+// kept, so a list made in any directory can be used. Blank lines and "#"
+// comments are ignored.
+func readChecksums(p string) (map[string]string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("line %d: expected a SHA-256 checksum and a file name", lineNumber)
+		}
+		if _, err := hex.DecodeString(fields[0]); err != nil {
+			return nil, fmt.Errorf("line %d: invalid checksum: %s", lineNumber, fields[0])
+		}
+		sums[path.Base(strings.TrimPrefix(fields[1], "*"))] = strings.ToLower(fields[0])
+	}
+	return sums, scanner.Err()
+}
+
+// Checks an archive file against its -checksums entry, if it has one.
+// Returns errChecksumMismatch if the file's checksum is different.
+func verifyChecksum(p string, name string) error {
+	expected, ok := checksums[path.Base(name)]
+	if !ok {
+		return nil
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("%s: expected %s, got %s: %w", name, expected, actual, errChecksumMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package importer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Ensures that a checksums file is read by file name and that comments and
+// malformed lines are handled.
+func TestReadChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sum := strings.Repeat("ab", 32)
+	var tests = []struct {
+		content string
+		sums    map[string]string
+		err     bool
+	}{
+		{"# comment\n\n" + sum + "  mirror/2013-01-01-0.json.gz\n", map[string]string{"2013-01-01-0.json.gz": sum}, false},
+		{strings.ToUpper(sum) + " *2013-01-01-1.json.gz\n", map[string]string{"2013-01-01-1.json.gz": sum}, false},
+		{"abc  2013-01-01-0.json.gz\n", nil, true},
+		{strings.Repeat("zz", 32) + "  2013-01-01-0.json.gz\n", nil, true},
+		{sum + "\n", nil, true},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, strconv.Itoa(i))
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sums, err := readChecksums(path)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: unexpected error: %v", tt.content, err)
+		} else if !tt.err && len(sums) != len(tt.sums) {
+			t.Errorf("%q: got %v", tt.content, sums)
+		}
+		for name, expected := range tt.sums {
+			if sums[name] != expected {
+				t.Errorf("%q: %s: got %q", tt.content, name, sums[name])
+			}
+		}
+	}
+}
+
+// Ensures that a verified download is only kept once it has as many bytes
+// as the server advertised, or all of them when no length was sent, and
+// that it's checked against -checksums.
+func TestDownloadVerified(t *testing.T) {
+	defer func(c map[string]string) { checksums = c }(checksums)
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("{\"id\":1}\n"))
+	w.Close()
+	content := gz.Bytes()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/match.json.gz":
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content)
+		case "/short.json.gz":
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)+100))
+			w.Write(content)
+		case "/unknown.json.gz":
+			// Flushing before writing leaves the length out of the
+			// response, which is then chunked.
+			w.(http.Flusher).Flush()
+			w.Write(content)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sum := sha256.Sum256(content)
+	checksums = map[string]string{"match.json.gz": hex.EncodeToString(sum[:]), "unknown.json.gz": strings.Repeat("0", 64)}
+
+	var tests = []struct {
+		name     string
+		ok       bool
+		mismatch bool
+	}{
+		{"match.json.gz", true, false},
+		{"short.json.gz", false, false},
+		{"unknown.json.gz", false, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		r, _, retryable, err := downloadVerified(context.Background(), server.URL+"/"+tt.name, path, tt.name)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			if b, err := ioutil.ReadAll(r); err != nil || string(b) != "{\"id\":1}\n" {
+				t.Errorf("%s: read %q: %v", tt.name, b, err)
+			}
+			r.Close()
+			continue
+		}
+
+		if err == nil || !retryable {
+			t.Errorf("%s: expected a retryable error, got %v, %v", tt.name, retryable, err)
+		} else if errors.Is(err, errChecksumMismatch) != tt.mismatch {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: incomplete download kept", tt.name)
+		}
+	}
+
+	// Without a checksum, a download with no length is kept as it is.
+	delete(checksums, "unknown.json.gz")
+	r, _, _, err := downloadVerified(context.Background(), server.URL+"/unknown.json.gz", filepath.Join(dir, "unknown.json.gz"), "unknown.json.gz")
+	if err != nil {
+		t.Fatalf("unknown length: %v", err)
+	}
+	r.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// dateRange is a START:END argument, covering the hours from the start up
+// to but not including the end.
+type dateRange struct {
+	start time.Time
+	end   time.Time
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Parses a START:END argument. Both dates can be in any of the forms
+// accepted on their own and the end can be "now". RFC3339 dates contain
+// colons themselves so each colon is tried as the separator.
+func parseDateRange(s string) (dateRange, error) {
+	for i := 0; i < len(s); i++ {
+		if s[i] != ':' {
+			continue
+		}
+		start, err := parseDate(s[:i])
+		if err != nil {
+			continue
+		}
+		if s[i+1:] == "now" {
+			return dateRange{start, time.Now().UTC().Truncate(time.Hour)}, nil
+		} else if end, err := parseDate(s[i+1:]); err == nil {
+			return dateRange{start, end}, nil
+		}
+	}
+	return dateRange{}, fmt.Errorf("Invalid date range: %s (expected START:END)", s)
+}
+
+// Returns true if an argument is a START:END range rather than a date.
+func isDateRange(s string) bool {
+	_, err := parseDateRange(s)
+	return err == nil
+}
+
+// Returns the hours in every range, a step apart from the start of each,
+// in order and without the duplicates from overlapping ranges.
+func rangeHours(ranges []dateRange, step time.Duration) []time.Time {
+	seen := map[time.Time]bool{}
+	var dates []time.Time
+	for _, r := range ranges {
+		for date := r.start; date.Before(r.end); date = date.Add(step) {
+			if !seen[date] {
+				seen[date] = true
+				dates = append(dates, date)
+			}
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Ensures that range arguments are split on the right colon.
+func TestParseDateRange(t *testing.T) {
+	tests := []struct {
+		arg   string
+		start time.Time
+		end   time.Time
+		ok    bool
+	}{
+		{"2024-01-01:2024-02-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2024-01-01-06:2024-01-01-12", time.Date(2024, 1, 1, 6, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), true},
+		{"2013-01-01T00:00:00Z:2013-01-02T00:00:00-08:00", time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2013, 1, 2, 8, 0, 0, 0, time.UTC), true},
+		{"2013-01-01T00:00:00Z", time.Time{}, time.Time{}, false},
+		{"2024-01-01", time.Time{}, time.Time{}, false},
+		{"2024-01-01:", time.Time{}, time.Time{}, false},
+		{"2024-01-01:feb", time.Time{}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		r, err := parseDateRange(tt.arg)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected error: %v", tt.arg, err)
+		} else if tt.ok && (!r.start.Equal(tt.start) || !r.end.Equal(tt.end)) {
+			t.Errorf("%s: unexpected range: %v to %v", tt.arg, r.start, r.end)
+		}
+	}
+}
+
+// Ensures that overlapping ranges become one sorted set of hours that
+// excludes each range's end.
+func TestRangeHours(t *testing.T) {
+	hour := func(d, h int) time.Time { return time.Date(2024, 3, d, h, 0, 0, 0, time.UTC) }
+	ranges := []dateRange{
+		{hour(2, 0), hour(2, 3)},
+		{hour(1, 22), hour(2, 1)},
+		{hour(1, 0), hour(1, 1)},
+	}
+	dates := rangeHours(ranges, time.Hour)
+	expected := []time.Time{hour(1, 0), hour(1, 22), hour(1, 23), hour(2, 0), hour(2, 1), hour(2, 2)}
+	if len(dates) != len(expected) {
+		t.Fatalf("Unexpected hours: %v", dates)
+	}
+	for i := range expected {
+		if !dates[i].Equal(expected[i]) {
+			t.Fatalf("Unexpected hour %d: %v", i, dates[i])
+		}
+	}
+}
+
+// Ensures that dates without an offset are read in the -tz zone while
+// dates with one are respected.
+func TestParseDateTimeZone(t *testing.T) {
+	defer func() { dateLocation = time.UTC }()
+	tests := []struct {
+		location *time.Location
+		arg      string
+		date     time.Time
+	}{
+		{time.UTC, "2024-01-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{time.FixedZone("PST", -8*3600), "2024-01-01", time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)},
+		{time.FixedZone("PST", -8*3600), "2024-01-01-23", time.Date(2024, 1, 2, 7, 0, 0, 0, time.UTC)},
+		{time.FixedZone("PST", -8*3600), "2024-01-01T00:00:00Z", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{time.FixedZone("IST", 5*3600+1800), "2024-01-01", time.Date(2023, 12, 31, 18, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		dateLocation = tt.location
+		date, err := parseDate(tt.arg)
+		if err != nil {
+			t.Errorf("%s in %s: %v", tt.arg, tt.location, err)
+		} else if !date.Equal(tt.date) || date.Location() != time.UTC {
+			t.Errorf("%s in %s: unexpected date: %v", tt.arg, tt.location, date)
+		}
+	}
+}
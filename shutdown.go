@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"github.com/daemonchen/sky-gharchive-importer/importer"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Returns a context that is cancelled on SIGINT or SIGTERM. The signal
+// also stops the import from starting new hours, while cancelling the
+// context aborts downloads in progress. A second signal exits immediately.
+func signalContext(imp *importer.Importer) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		warn("Received %v, stopping. Send again to exit immediately.", sig)
+		imp.Stop("interrupted by " + sig.String())
+		cancel()
+
+		<-c
+		os.Exit(exitInterrupted)
+	}()
+
+	return ctx
+}
@@ -1,17 +1,14 @@
 package main
 
 import (
-	"bufio"
-	"compress/gzip"
-	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
-	"github.com/skydb/sky.go"
-	"io"
-	"net/http"
+	"github.com/daemonchen/sky-gharchive-importer/importer"
+	"io/ioutil"
 	"os"
 	"runtime"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -25,20 +22,142 @@ const (
 	Version = "0.3.0"
 )
 
+// The number of hours past the current hour an end date may be.
+const maxFutureHours = 24
+
 const (
-	defaultHost      = "localhost"
-	defaultPort      = 8585
-	defaultTableName = "gharchive"
-	defaultOverwrite = false
-	defaultVerbose   = false
+	defaultVerbose        = false
+	defaultListMissing    = false
+	defaultDumpUnmapped   = false
+	defaultDumpSample     = 10000
+	defaultRetryManifest  = ""
+	defaultRequireFields  = ""
+	defaultStrictFields   = ""
+	defaultSchemaPath     = ""
+	defaultEventTypes     = ""
+	defaultLogLevel       = "info"
+	defaultLogFormat      = "text"
+	defaultHoursFile      = ""
+	defaultUsers          = ""
+	defaultUsersFile      = ""
+	defaultSchemaOnly     = false
+	defaultRepos          = ""
+	defaultReposFile      = ""
+	defaultStdin          = false
+	defaultProbe          = false
+	defaultFlattenPayload = false
+	defaultStatusFile     = ""
+	defaultTimeZone       = "UTC"
 )
 
 const (
-	hostUsage      = "the host the Sky server is running on"
-	portUsage      = "the port the Sky server is running on"
-	tableNameUsage = "the table to insert events into"
-	overwriteUsage = "overwrite an existing table if one exists"
-	verboseUsage   = "verbose logging"
+	hostUsage                = "the host the Sky server is running on"
+	portUsage                = "the port the Sky server is running on"
+	tableNameUsage           = "the table to insert events into"
+	overwriteUsage           = "overwrite an existing table if one exists"
+	verboseUsage             = "debug logging, the same as -log-level debug"
+	maxFactorValuesUsage     = "the maximum distinct values allowed per factor property (0 disables the guard)"
+	factorOverflowUsage      = "what to do when a factor exceeds its limit: 'abort' or 'warn'"
+	listMissingUsage         = "report hours missing from the archive without importing"
+	timeWindowUsage          = "commit sorted events in windows of event time (e.g. 10m) instead of one at a time"
+	dumpUnmappedUsage        = "report record fields that are not mapped to a property without importing"
+	dumpSampleUsage          = "the number of records to sample for the unmapped field report (0 reads the whole range)"
+	progressSocketUsage      = "a Unix socket path to stream JSON status updates to"
+	progressIntervalUsage    = "how often status updates are written to the progress socket"
+	globalOrderUsage         = "commit events in timestamp order across hour boundaries (slower, holds more events in memory)"
+	globalOrderWindowUsage   = "the number of hours to hold back for ordering in global order mode"
+	manifestPathUsage        = "a file to record the outcome of each hour in"
+	retryManifestUsage       = "import only the hours a previous manifest marked as failed or skipped"
+	maxFactorLengthUsage     = "the maximum length in bytes of a factor value (0 is unlimited)"
+	factorLengthModeUsage    = "what to do with factor values over the maximum length: 'truncate' or 'drop'"
+	dualWriteUsage           = "also write every event added to Sky to this NDJSON file"
+	dualWriteValidateUsage   = "compare per-hour event counts between Sky and the dual write file"
+	skipPingOnReconnectUsage = "retry failed writes directly instead of waiting for the server to answer a ping"
+	streamWorkersUsage       = "the number of workers adding events to Sky, each with its own connection"
+	canonicalJSONUsage       = "write reproducible JSON to output files (sorted keys, fixed number encoding)"
+	maxRedirectsUsage        = "the maximum number of redirects to follow when fetching an archive"
+	stripAuthOnRedirectUsage = "drop the Authorization header when redirected to a different host"
+	onlyNewUsersUsage        = "only import the first event seen for each user"
+	bloomCapacityUsage       = "the expected number of distinct users for -only-new-users"
+	bloomFPRateUsage         = "the false positive rate of the -only-new-users filter"
+	clampToHourUsage         = "move event timestamps outside their file's hour to the nearest edge of the hour"
+	requireFieldsUsage       = "a comma-separated list of properties an event must have to be imported"
+	archiveExtUsage          = "the extension of the hourly archive files (e.g. .json.zst)"
+	partitionByUsage         = "partition output files into key=value directories by this property"
+	maxOpenPartitionsUsage   = "the maximum number of partition files open at once"
+	maxRuntimeUsage          = "stop cleanly after this much wall-clock time, recording unfinished hours as skipped"
+	factorNumberFormatUsage  = "how numbers stored in factor properties are formatted: 'plain', 'int' or a fmt verb like '%.2f'"
+	replayRejectsUsage       = "re-import the records in a rejects file instead of a date range"
+	strictSchemaUsage        = "check records for non-null fields that aren't mapped: 'warn' or 'error'"
+	strictFieldsUsage        = "a comma-separated list of record paths to check in strict mode instead of the top-level fields"
+	parseWorkersUsage        = "the number of workers decoding JSON records"
+	statsIntervalUsage       = "how often to log throughput and latency for the parse and stream stages (0 disables)"
+	retriesUsage             = "the number of times to retry an archive download after a network or server error"
+	retryBaseDelayUsage      = "the delay before the first retry, doubling with each attempt"
+	concurrencyUsage         = "the number of hours to download and parse at once"
+	sourceDirUsage           = "read hourly archive files from this directory instead of downloading them"
+	batchSizeUsage           = "the number of events to add to Sky before reading more of the file (0 is unbounded)"
+	presortUsage             = "hold each hour's events in memory and add them in timestamp order (uses memory for a whole hour)"
+	checkpointPathUsage      = "a file to record the last imported hour in and to resume after on the next run"
+	restartUsage             = "ignore and clear an existing checkpoint"
+	schemaPathUsage          = "a JSON file listing the properties to create and the record path each is read from"
+	dryRunUsage              = "download and parse every hour without creating the table or adding events"
+	eventTypesUsage          = "a comma-separated list of event types to import, such as PushEvent (default all)"
+	httpTimeoutUsage         = "the time allowed to download an archive, including reading it (0 is unlimited)"
+	baseURLUsage             = "the URL of the archive or a mirror of it that hourly files are downloaded from"
+	rejectsPathUsage         = "a file to append records that could not be imported to, with the reason"
+	streamBufferUsage        = "the number of events that can be queued for each stream worker before readers wait"
+	eventLimitUsage          = "stop after adding this many events (0 is unlimited)"
+	cacheDirUsage            = "a directory to keep downloaded archive files in and read them from on later runs"
+	noCacheWriteUsage        = "read archive files from the cache directory but don't add new ones"
+	logLevelUsage            = "the most verbose messages logged: error, warn, info or debug"
+	logFormatUsage           = "the format of log lines: text or json"
+	dedupeWindowUsage        = "skip events matching one of the last N events imported by user, timestamp and type (0 disables)"
+	stepUsage                = "the time between hours imported from the range, a multiple of an hour"
+	hoursFileUsage           = "a file listing the hours to import, one per line, instead of a range"
+	metricsAddrUsage         = "an address such as :9100 to serve Prometheus metrics on at /metrics"
+	usersUsage               = "a comma-separated list of the only users to import events for"
+	usersFileUsage           = "a file listing the only users to import events for, one login per line"
+	schemaOnlyUsage          = "create the table and its properties and exit without importing"
+	outputPathUsage          = "write events to this file as JSON lines instead of adding them to Sky ('-' for stdout)"
+	maxRPSUsage              = "the maximum number of requests per second made to the archive host (0 is unlimited)"
+	hourTimeoutUsage         = "skip an hour that takes longer than this to download and import (0 is unlimited)"
+	retryListPathUsage       = "a file to append hours that timed out to, for re-running with -hours-file"
+	bundlePathUsage          = "a tar, tar.gz or zip file of hourly archive files to import instead of downloading them"
+	continueOnErrorUsage     = "keep importing after an hour fails; use -continue-on-error=false to stop instead"
+	truncateUsage            = "truncate event timestamps to this resolution, such as 1s or 1m (0 keeps them as is)"
+	reposUsage               = "a comma-separated list of the only repositories to import events for, as owner/name"
+	reposFileUsage           = "a file listing the only repositories to import events for, one owner/name per line"
+	reorderWindowUsage       = "with -concurrency, add each hour's events in hour order, starting at most this many hours past the oldest one not yet added (0 disables)"
+	logSampleUsage           = "log every Nth parsed event in full for spot-checking (0 disables)"
+	reconnectRetriesUsage    = "the number of times to reconnect to Sky after a lost connection before aborting"
+	reconnectDelayUsage      = "the delay before the first reconnect to Sky, doubling with each attempt"
+	stdinUsage               = "import newline-delimited archive records from standard input instead of a date range"
+	probeUsage               = "print the first and last archive hours available around the start date and exit"
+	flattenPayloadUsage      = "also import common payload fields, such as payload_action and payload_merged, as properties"
+	maxMemoryUsage           = "a heap size, such as 2GB, near which held events are written early to free memory"
+	headerUsage              = "a header to send with archive requests, as \"Name: value\" (repeatable)"
+	countActionsUsage        = "count each user's events of each type in memory and write the tallies at the end"
+	countActionsOnlyUsage    = "write only the -count-actions tallies, not the events themselves"
+	countActionsOutputUsage  = "where to write the -count-actions tallies: 'sky' for one event per user, or a JSON lines file"
+	resumeLinesUsage         = "with -checkpoint, also record how far into each hour events have been written and resume a failed hour from there"
+	showProgressUsage        = "show hours done, throughput and an ETA on standard error, as a bar on a terminal"
+	preflightUsage           = "fail before importing if a table property is missing or differs from the schema in type or transient flag"
+	maxSkipRateUsage         = "abort when more than this fraction of an hour's records can't be turned into events, such as 0.5 (0 disables)"
+	compactDataUsage         = "share one copy of each repeated factor value between events to reduce memory use"
+	statusFileUsage          = "write the outcome of the run, its counts and its configuration to this file as JSON"
+	replayUsage              = "alias for -replay-rejects"
+	maxIdleConnsUsage        = "the number of idle connections to the archive host kept for reuse (0 keeps one per -concurrency worker)"
+	idleConnTimeoutUsage     = "how long an idle connection to the archive host is kept for reuse"
+	timeZoneUsage            = "the IANA time zone that dates without an offset are given in, such as America/Los_Angeles"
+	maxRetriesUsage          = "alias for -retries"
+	archiveURLUsage          = "alias for -base-url; a URL containing {file}, {year}, {month}, {day} or {hour} is a template for each hour's file"
+	verifyDownloadUsage      = "download each hour in full and check its length before importing any of it"
+	checksumsPathUsage       = "a sha256sum file of expected archive checksums; files that don't match are downloaded again or rejected"
+	proxyUsage               = "the proxy URL for archive requests, overriding HTTP_PROXY and HTTPS_PROXY"
+	maxBandwidthUsage        = "the most bytes per second downloaded from the archive across every worker, such as 5MB/s"
+	cacheRevalidateUsage     = "with -cache-dir, check each cached hour with a conditional request and download it again if it changed"
+	propertyUsage            = "change a property's type and transient flag as \"name:type:transient\", or add one with \":path\" (repeatable)"
 )
 
 //------------------------------------------------------------------------------
@@ -47,11 +166,32 @@ const (
 //
 //------------------------------------------------------------------------------
 
-var host string
-var port int
-var tableName string
-var overwrite bool
+// The options of the import, set by the flags that share their names.
+var config = importer.DefaultConfig()
+
 var verbose bool
+var listMissing bool
+var dumpUnmapped bool
+var dumpSample int
+var retryManifest string
+var requireFields string
+var strictFields string
+var schemaPath string
+var eventTypes string
+var logLevelName string
+var logFormat string
+var hoursFile string
+var users string
+var usersFile string
+var schemaOnly bool
+var repos string
+var reposFile string
+var readStdin bool
+var probe bool
+var flattenPayload bool
+var statusFile string
+var timeZone string
+var dateLocation = time.UTC
 
 //------------------------------------------------------------------------------
 //
@@ -64,15 +204,117 @@ var verbose bool
 //--------------------------------------
 
 func init() {
-	flag.StringVar(&host, "host", defaultHost, hostUsage)
-	flag.StringVar(&host, "h", defaultHost, hostUsage+" (shorthand)")
-	flag.IntVar(&port, "port", defaultPort, portUsage)
-	flag.IntVar(&port, "p", defaultPort, portUsage+" (shorthand)")
-	flag.StringVar(&tableName, "table", defaultTableName, tableNameUsage)
-	flag.StringVar(&tableName, "t", defaultTableName, tableNameUsage+" (shorthand)")
-	flag.BoolVar(&overwrite, "overwrite", defaultOverwrite, overwriteUsage)
+	flag.StringVar(&config.Host, "host", config.Host, hostUsage)
+	flag.StringVar(&config.Host, "h", config.Host, hostUsage+" (shorthand)")
+	flag.IntVar(&config.Port, "port", config.Port, portUsage)
+	flag.IntVar(&config.Port, "p", config.Port, portUsage+" (shorthand)")
+	flag.StringVar(&config.TableName, "table", config.TableName, tableNameUsage)
+	flag.StringVar(&config.TableName, "t", config.TableName, tableNameUsage+" (shorthand)")
+	flag.BoolVar(&config.Overwrite, "overwrite", config.Overwrite, overwriteUsage)
 	flag.BoolVar(&verbose, "v", defaultVerbose, verboseUsage)
 	flag.BoolVar(&verbose, "verbose", defaultVerbose, verboseUsage)
+	flag.IntVar(&config.MaxFactorValues, "max-factor-values", config.MaxFactorValues, maxFactorValuesUsage)
+	flag.StringVar(&config.FactorOverflow, "factor-overflow", config.FactorOverflow, factorOverflowUsage)
+	flag.BoolVar(&listMissing, "list-missing", defaultListMissing, listMissingUsage)
+	flag.DurationVar(&config.TimeWindow, "time-window", config.TimeWindow, timeWindowUsage)
+	flag.BoolVar(&dumpUnmapped, "dump-unmapped", defaultDumpUnmapped, dumpUnmappedUsage)
+	flag.IntVar(&dumpSample, "dump-sample", defaultDumpSample, dumpSampleUsage)
+	flag.StringVar(&config.ProgressSocket, "progress-socket", config.ProgressSocket, progressSocketUsage)
+	flag.DurationVar(&config.ProgressInterval, "progress-interval", config.ProgressInterval, progressIntervalUsage)
+	flag.BoolVar(&config.GlobalOrder, "global-order", config.GlobalOrder, globalOrderUsage)
+	flag.IntVar(&config.GlobalOrderWindow, "global-order-window", config.GlobalOrderWindow, globalOrderWindowUsage)
+	flag.StringVar(&config.ManifestPath, "manifest", config.ManifestPath, manifestPathUsage)
+	flag.StringVar(&retryManifest, "retry-manifest", defaultRetryManifest, retryManifestUsage)
+	flag.IntVar(&config.MaxFactorLength, "max-factor-len", config.MaxFactorLength, maxFactorLengthUsage)
+	flag.StringVar(&config.FactorLengthMode, "factor-len-action", config.FactorLengthMode, factorLengthModeUsage)
+	flag.StringVar(&config.DualWrite, "dual-write", config.DualWrite, dualWriteUsage)
+	flag.BoolVar(&config.DualWriteValidate, "dual-write-validate", config.DualWriteValidate, dualWriteValidateUsage)
+	flag.BoolVar(&config.SkipPingOnReconnect, "skip-ping-on-reconnect", config.SkipPingOnReconnect, skipPingOnReconnectUsage)
+	flag.IntVar(&config.StreamWorkers, "stream-workers", config.StreamWorkers, streamWorkersUsage)
+	flag.BoolVar(&config.CanonicalJSON, "canonical-json", config.CanonicalJSON, canonicalJSONUsage)
+	flag.IntVar(&config.MaxRedirects, "max-redirects", config.MaxRedirects, maxRedirectsUsage)
+	flag.BoolVar(&config.StripAuthOnRedirect, "strip-auth-on-redirect", config.StripAuthOnRedirect, stripAuthOnRedirectUsage)
+	flag.BoolVar(&config.OnlyNewUsers, "only-new-users", config.OnlyNewUsers, onlyNewUsersUsage)
+	flag.IntVar(&config.BloomCapacity, "bloom-capacity", config.BloomCapacity, bloomCapacityUsage)
+	flag.Float64Var(&config.BloomFPRate, "bloom-fp-rate", config.BloomFPRate, bloomFPRateUsage)
+	flag.BoolVar(&config.ClampToHour, "clamp-to-hour", config.ClampToHour, clampToHourUsage)
+	flag.StringVar(&requireFields, "require-fields", defaultRequireFields, requireFieldsUsage)
+	flag.StringVar(&config.ArchiveExt, "archive-ext", config.ArchiveExt, archiveExtUsage)
+	flag.StringVar(&config.PartitionBy, "partition-by", config.PartitionBy, partitionByUsage)
+	flag.IntVar(&config.MaxOpenPartitions, "max-open-partitions", config.MaxOpenPartitions, maxOpenPartitionsUsage)
+	flag.DurationVar(&config.MaxRuntime, "max-runtime", config.MaxRuntime, maxRuntimeUsage)
+	flag.StringVar(&config.FactorNumberFormat, "factor-number-format", config.FactorNumberFormat, factorNumberFormatUsage)
+	flag.StringVar(&config.ReplayRejects, "replay-rejects", config.ReplayRejects, replayRejectsUsage)
+	flag.StringVar(&config.StrictSchema, "strict-schema", config.StrictSchema, strictSchemaUsage)
+	flag.StringVar(&strictFields, "strict-fields", defaultStrictFields, strictFieldsUsage)
+	flag.IntVar(&config.ParseWorkers, "parse-workers", config.ParseWorkers, parseWorkersUsage)
+	flag.DurationVar(&config.StatsInterval, "stats-interval", config.StatsInterval, statsIntervalUsage)
+	flag.IntVar(&config.Retries, "retries", config.Retries, retriesUsage)
+	flag.DurationVar(&config.RetryBaseDelay, "retry-base-delay", config.RetryBaseDelay, retryBaseDelayUsage)
+	flag.IntVar(&config.Concurrency, "concurrency", config.Concurrency, concurrencyUsage)
+	flag.StringVar(&config.SourceDir, "source-dir", config.SourceDir, sourceDirUsage)
+	flag.IntVar(&config.BatchSize, "batch-size", config.BatchSize, batchSizeUsage)
+	flag.BoolVar(&config.Presort, "presort", config.Presort, presortUsage)
+	flag.StringVar(&config.CheckpointPath, "checkpoint", config.CheckpointPath, checkpointPathUsage)
+	flag.BoolVar(&config.Restart, "restart", config.Restart, restartUsage)
+	flag.StringVar(&schemaPath, "schema", defaultSchemaPath, schemaPathUsage)
+	flag.BoolVar(&config.DryRun, "dry-run", config.DryRun, dryRunUsage)
+	flag.StringVar(&eventTypes, "event-types", defaultEventTypes, eventTypesUsage)
+	flag.DurationVar(&config.HTTPTimeout, "http-timeout", config.HTTPTimeout, httpTimeoutUsage)
+	flag.StringVar(&config.BaseURL, "base-url", config.BaseURL, baseURLUsage)
+	flag.StringVar(&config.RejectsPath, "rejects", config.RejectsPath, rejectsPathUsage)
+	flag.IntVar(&config.StreamBuffer, "stream-buffer", config.StreamBuffer, streamBufferUsage)
+	flag.IntVar(&config.EventLimit, "limit", config.EventLimit, eventLimitUsage)
+	flag.StringVar(&config.CacheDir, "cache-dir", config.CacheDir, cacheDirUsage)
+	flag.BoolVar(&config.NoCacheWrite, "no-cache-write", config.NoCacheWrite, noCacheWriteUsage)
+	flag.StringVar(&logLevelName, "log-level", defaultLogLevel, logLevelUsage)
+	flag.StringVar(&logFormat, "log-format", defaultLogFormat, logFormatUsage)
+	flag.IntVar(&config.DedupeWindow, "dedupe-window", config.DedupeWindow, dedupeWindowUsage)
+	flag.DurationVar(&config.Step, "step", config.Step, stepUsage)
+	flag.StringVar(&hoursFile, "hours-file", defaultHoursFile, hoursFileUsage)
+	flag.StringVar(&config.MetricsAddr, "metrics-addr", config.MetricsAddr, metricsAddrUsage)
+	flag.StringVar(&users, "users", defaultUsers, usersUsage)
+	flag.StringVar(&usersFile, "users-file", defaultUsersFile, usersFileUsage)
+	flag.BoolVar(&schemaOnly, "schema-only", defaultSchemaOnly, schemaOnlyUsage)
+	flag.StringVar(&config.OutputPath, "output", config.OutputPath, outputPathUsage)
+	flag.Float64Var(&config.MaxRPS, "max-rps", config.MaxRPS, maxRPSUsage)
+	flag.DurationVar(&config.HourTimeout, "hour-timeout", config.HourTimeout, hourTimeoutUsage)
+	flag.StringVar(&config.RetryListPath, "retry-list", config.RetryListPath, retryListPathUsage)
+	flag.StringVar(&config.BundlePath, "archive", config.BundlePath, bundlePathUsage)
+	flag.BoolVar(&config.ContinueOnError, "continue-on-error", config.ContinueOnError, continueOnErrorUsage)
+	flag.DurationVar(&config.Truncate, "truncate", config.Truncate, truncateUsage)
+	flag.StringVar(&repos, "repos", defaultRepos, reposUsage)
+	flag.StringVar(&reposFile, "repos-file", defaultReposFile, reposFileUsage)
+	flag.IntVar(&config.ReorderWindow, "reorder-window", config.ReorderWindow, reorderWindowUsage)
+	flag.IntVar(&config.LogSample, "log-sample", config.LogSample, logSampleUsage)
+	flag.IntVar(&config.ReconnectRetries, "reconnect-retries", config.ReconnectRetries, reconnectRetriesUsage)
+	flag.DurationVar(&config.ReconnectDelay, "reconnect-delay", config.ReconnectDelay, reconnectDelayUsage)
+	flag.BoolVar(&readStdin, "stdin", defaultStdin, stdinUsage)
+	flag.BoolVar(&probe, "probe", defaultProbe, probeUsage)
+	flag.BoolVar(&flattenPayload, "flatten-payload", defaultFlattenPayload, flattenPayloadUsage)
+	flag.StringVar(&config.MaxMemory, "max-memory", config.MaxMemory, maxMemoryUsage)
+	flag.Var(headerFlag(config.RequestHeaders), "header", headerUsage)
+	flag.BoolVar(&config.CountActions, "count-actions", config.CountActions, countActionsUsage)
+	flag.BoolVar(&config.CountActionsOnly, "count-actions-only", config.CountActionsOnly, countActionsOnlyUsage)
+	flag.StringVar(&config.CountActionsOutput, "count-actions-output", config.CountActionsOutput, countActionsOutputUsage)
+	flag.BoolVar(&config.ResumeLines, "resume-lines", config.ResumeLines, resumeLinesUsage)
+	flag.BoolVar(&config.ShowProgress, "progress", config.ShowProgress, showProgressUsage)
+	flag.BoolVar(&config.Preflight, "preflight", config.Preflight, preflightUsage)
+	flag.Float64Var(&config.MaxSkipRate, "max-skip-rate", config.MaxSkipRate, maxSkipRateUsage)
+	flag.BoolVar(&config.CompactData, "compact-data", config.CompactData, compactDataUsage)
+	flag.StringVar(&statusFile, "status-file", defaultStatusFile, statusFileUsage)
+	flag.Var(&propertyOverrides, "property", propertyUsage)
+	flag.StringVar(&config.ReplayRejects, "replay", config.ReplayRejects, replayUsage)
+	flag.IntVar(&config.MaxIdleConns, "max-idle-conns-per-host", config.MaxIdleConns, maxIdleConnsUsage)
+	flag.DurationVar(&config.IdleConnTimeout, "idle-conn-timeout", config.IdleConnTimeout, idleConnTimeoutUsage)
+	flag.StringVar(&timeZone, "tz", defaultTimeZone, timeZoneUsage)
+	flag.IntVar(&config.Retries, "max-retries", config.Retries, maxRetriesUsage)
+	flag.StringVar(&config.BaseURL, "archive-url", config.BaseURL, archiveURLUsage)
+	flag.BoolVar(&config.VerifyDownload, "verify-download", config.VerifyDownload, verifyDownloadUsage)
+	flag.StringVar(&config.ChecksumsPath, "checksums", config.ChecksumsPath, checksumsPathUsage)
+	flag.Var(&requestProxy, "proxy", proxyUsage)
+	flag.StringVar(&config.MaxBandwidth, "max-bandwidth", config.MaxBandwidth, maxBandwidthUsage)
+	flag.BoolVar(&config.CacheRevalidate, "cache-revalidate", config.CacheRevalidate, cacheRevalidateUsage)
 }
 
 //--------------------------------------
@@ -82,179 +324,378 @@ func init() {
 func main() {
 	var err error
 
-	// Parse the command line arguments.
-	flag.Parse()
+	// Parse the command line arguments. A bad flag is a fatal error rather
+	// than the flag package's usual status of 2, which means a partial run.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err = flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(exitOK)
+	} else if err != nil {
+		os.Exit(exitFatal)
+	}
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	// Parse start and end date.
-	var startDate, endDate time.Time
-	if flag.NArg() == 0 {
-		usage()
-	} else if flag.NArg() == 1 {
-		if startDate, err = time.Parse(time.RFC3339, flag.Arg(0)); err != nil {
-			warn("Invalid start date: %s", flag.Arg(0))
-			os.Exit(1)
+	// Don't leave an earlier run's status to be mistaken for this one's.
+	if statusFile != "" {
+		os.Remove(statusFile)
+	}
+
+	if verbose {
+		logLevelName = "debug"
+	}
+	if err = importer.SetLogOptions(logLevelName, logFormat); err != nil {
+		logError("%v", err)
+		os.Exit(exitFatal)
+	}
+
+	// Read the property mapping.
+	if schemaPath != "" {
+		if config.Schema, err = importer.ReadSchema(schemaPath); err != nil {
+			logError("%v", err)
+			os.Exit(exitFatal)
 		}
-		endDate = startDate
-	} else {
-		if startDate, err = time.Parse(time.RFC3339, flag.Arg(0)); err != nil {
-			warn("Invalid start date: %s", flag.Arg(0))
-			os.Exit(1)
+	}
+	if flattenPayload {
+		if config.Schema, err = importer.ExtendSchema(config.Schema, importer.PayloadSchema()); err != nil {
+			logError("%v", err)
+			os.Exit(exitFatal)
 		}
-		if endDate, err = time.Parse(time.RFC3339, flag.Arg(1)); err != nil {
-			warn("Invalid end date: %s", flag.Arg(1))
-			os.Exit(1)
+	}
+	if len(propertyOverrides) > 0 {
+		if config.Schema, err = importer.OverrideSchema(config.Schema, propertyOverrides); err != nil {
+			logError("%v", err)
+			os.Exit(exitFatal)
 		}
 	}
 
-	// Setup the client and table.
-	_, table, err := setup()
-	if err != nil {
-		warn("%v", err)
-		os.Exit(1)
+	// Read dates without an offset in the given zone.
+	if dateLocation, err = time.LoadLocation(timeZone); err != nil {
+		logError("Invalid time zone: %s", timeZone)
+		os.Exit(exitFatal)
+	}
+	config.DateLocation = dateLocation
+
+	// Select the records to import.
+	config.EventTypes = splitList(eventTypes)
+	config.RequiredFields = splitList(requireFields)
+	config.StrictFields = splitList(strictFields)
+	if config.Users, err = readNames(users, usersFile); err != nil {
+		logError("Invalid users file: %v", err)
+		os.Exit(exitFatal)
+	}
+	if config.Repos, err = readNames(repos, reposFile); err != nil {
+		logError("Invalid repos file: %v", err)
+		os.Exit(exitFatal)
+	}
+	config.Proxy = requestProxy.URL
+	if readStdin {
+		config.Input = os.Stdin
 	}
 
-	// Loop over date range.
-	hours := int(endDate.Sub(startDate)/time.Hour) + 1
-	for i := 0; i < hours; i++ {
-		date := startDate.Add(time.Duration(i) * time.Hour)
-		if err = importDate(table, date); err != nil {
-			warn("Invalid file: %v", err)
+	// Provision the table without importing anything.
+	if schemaOnly {
+		if config.DryRun {
+			logError("-schema-only can't be used with -dry-run.")
+			os.Exit(exitFatal)
+		}
+		if err = newImporter().CreateTable(); err != nil {
+			logError("%v", err)
+			os.Exit(exitFatal)
 		}
+		return
 	}
-}
 
-func usage() {
-	warn("usage: sky-gha-importer [OPTIONS] START_DATE END_DATE")
-	os.Exit(1)
-}
+	// Find the range of hours the archive has without touching Sky.
+	if probe {
+		if flag.NArg() == 0 {
+			usage()
+		}
+		start, err := parseDate(flag.Arg(0))
+		if err != nil {
+			logError("Invalid start date: %s", flag.Arg(0))
+			os.Exit(exitFatal)
+		}
+		imp := newImporter()
+		first, last, err := imp.Probe(signalContext(imp), start)
+		if err != nil {
+			logError("%v", err)
+			os.Exit(exitFatal)
+		}
+		fmt.Printf("%s %s\n", first.Format("2006-01-02-15"), last.Format("2006-01-02-15"))
+		return
+	}
 
-//--------------------------------------
-// Setup
-//--------------------------------------
+	// Determine the hours to import, either from a previous manifest or
+	// from the start and end date.
+	var dates []time.Time
+	if config.ReplayRejects != "" || readStdin {
+		// Records come from the rejects file or standard input.
+	} else if retryManifest != "" {
+		if dates, err = importer.RetryHours(retryManifest); err != nil {
+			logError("Invalid manifest: %v", err)
+			os.Exit(exitFatal)
+		}
+		info("Retrying %d hours from %s.", len(dates), retryManifest)
+	} else if hoursFile != "" {
+		if dates, err = readHoursFile(hoursFile); err != nil {
+			logError("Invalid hours file: %v", err)
+			os.Exit(exitFatal)
+		}
+	} else {
+		if config.Step <= 0 || config.Step%time.Hour != 0 {
+			logError("Invalid step: %v (expected a multiple of an hour)", config.Step)
+			os.Exit(exitFatal)
+		}
+		if flag.NArg() == 0 {
+			usage()
+		} else if isFileArg(flag.Arg(0)) {
+			if config.LocalFiles, err = fileArgHours(flag.Args()); err != nil {
+				logError("%v", err)
+				os.Exit(exitFatal)
+			}
+			for date := range config.LocalFiles {
+				dates = append(dates, date)
+			}
+			sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+		} else if isDateRange(flag.Arg(0)) {
+			dates = rangeArgHours(flag.Args())
+		} else {
+			dates = dateArgHours(flag.Args())
+		}
+	}
+	imp := newImporter()
+	ctx := signalContext(imp)
+
+	// Report gaps in the archive without touching Sky.
+	if listMissing {
+		missing, err := imp.ListMissing(ctx, dates)
+		if err != nil {
+			logError("%v", err)
+			os.Exit(exitFatal)
+		} else if missing > 0 {
+			os.Exit(exitFatal)
+		}
+		return
+	}
 
-func setup() (*sky.Client, *sky.Table, error) {
-	warn("Connecting to %s:%d.\n", host, port)
+	// Report fields the importer ignores without touching Sky.
+	if dumpUnmapped {
+		if err = imp.DumpUnmapped(ctx, dates, dumpSample); err != nil {
+			logError("%v", err)
+			os.Exit(exitFatal)
+		}
+		return
+	}
 
-	// Create a Sky client.
-	client := sky.NewClient(host)
-	client.Port = port
+	result, err := imp.RunHours(ctx, dates)
+	if result == nil {
+		logError("%v", err)
+		os.Exit(exitFatal)
+	}
+	status, code := exitStatus(ctx.Err() != nil, err, result.Incomplete)
+
+	if statusFile != "" {
+		s := &runStatus{
+			Status:         status,
+			ExitCode:       code,
+			StartTime:      result.StartTime,
+			EndTime:        result.EndTime,
+			Duration:       result.EndTime.Sub(result.StartTime).String(),
+			HoursTotal:     result.HoursTotal,
+			HoursCompleted: result.HoursCompleted,
+			HoursFailed:    result.HoursFailed,
+			HoursSkipped:   result.HoursSkipped,
+			FailedHours:    result.FailedHours,
+			SkippedHours:   result.SkippedHours,
+			EventsRead:     result.EventsRead,
+			EventsAdded:    result.EventsAdded,
+			EventsSkipped:  result.EventsSkipped,
+			SkipReasons:    result.SkipReasons,
+			Args:           flag.Args(),
+			Config:         effectiveConfig(flag.CommandLine),
+		}
+		if err != nil {
+			s.Error = err.Error()
+		}
+		if err := writeStatusFile(statusFile, s); err != nil {
+			warn("Unable to write status file: %v", err)
+		}
+	}
 
-	// Check if the server is running.
-	if !client.Ping() {
-		return nil, nil, errors.New("Server is not running.")
+	if code != exitOK {
+		os.Exit(code)
 	}
+}
 
-	// Check if the table exists first.
-	table, err := client.GetTable(tableName)
-	if table != nil {
-		if overwrite {
-			err = client.DeleteTable(table)
-			if err != nil {
-				return nil, nil, err
-			}
-			table = nil
-		}
+// Checks the options and creates the importer, exiting if they're invalid.
+func newImporter() *importer.Importer {
+	imp, err := importer.New(config)
+	if err != nil {
+		logError("%v", err)
+		os.Exit(exitFatal)
 	}
+	return imp
+}
 
-	if table == nil {
-		// Create the table.
-		table = sky.NewTable(tableName, client)
-		if err = client.CreateTable(table); err != nil {
-			return nil, nil, err
+func usage() {
+	logError("usage: sky-gha-importer [OPTIONS] START_DATE [END_DATE|now]")
+	logError("       sky-gha-importer [OPTIONS] START:END [START:END...]")
+	logError("       sky-gha-importer [OPTIONS] FILE|GLOB [FILE|GLOB...]")
+	os.Exit(exitFatal)
+}
+
+// Returns the hours between a START_DATE and optional END_DATE argument,
+// exiting if either is invalid.
+func dateArgHours(args []string) []time.Time {
+	startDate, err := parseDate(args[0])
+	if err != nil {
+		logError("Invalid start date: %s", args[0])
+		os.Exit(exitFatal)
+	}
+	endDate := startDate
+	if len(args) > 1 {
+		if args[1] == "now" {
+			endDate = time.Now().UTC().Truncate(time.Hour)
+		} else if endDate, err = parseDate(args[1]); err != nil {
+			logError("Invalid end date: %s", args[1])
+			os.Exit(exitFatal)
 		}
+	}
+	if endDate.Before(startDate) {
+		logError("End date %s is before start date %s.", endDate.Format(time.RFC3339), startDate.Format(time.RFC3339))
+		usage()
+	}
+	checkFutureDates(startDate, endDate)
+	return importer.Hours(startDate, endDate, config.Step)
+}
 
-		// Add properties.
-		properties := []*sky.Property{
-			sky.NewProperty("username", false, sky.String),
-			sky.NewProperty("action", true, sky.Factor),
-			sky.NewProperty("language", true, sky.Factor),
-			sky.NewProperty("forks", true, sky.Integer),
-			sky.NewProperty("watchers", true, sky.Integer),
-			sky.NewProperty("stargazers", true, sky.Integer),
-			sky.NewProperty("size", true, sky.Integer),
+// Returns the hours in a list of START:END arguments as one sorted set,
+// exiting if any range is invalid.
+func rangeArgHours(args []string) []time.Time {
+	ranges := make([]dateRange, len(args))
+	for i, arg := range args {
+		r, err := parseDateRange(arg)
+		if err != nil {
+			logError("%v", err)
+			usage()
 		}
-		for _, property := range properties {
-			if err = table.CreateProperty(property); err != nil {
-				return nil, nil, err
-			}
+		if !r.end.After(r.start) {
+			logError("End date %s is not after start date %s.", r.end.Format(time.RFC3339), r.start.Format(time.RFC3339))
+			usage()
 		}
+		checkFutureDates(r.start, r.end.Add(-time.Hour))
+		ranges[i] = r
 	}
+	return rangeHours(ranges, config.Step)
+}
 
-	return client, table, nil
+// Exits if the first hour to import is in the future or the last is more
+// than a day ahead. The rest of today is allowed since its hours are
+// skipped until they're published.
+func checkFutureDates(startDate, endDate time.Time) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	if startDate.After(now) {
+		logError("Start date %s is in the future.", startDate.Format(time.RFC3339))
+		usage()
+	} else if endDate.After(now.Add(maxFutureHours * time.Hour)) {
+		logError("End date %s is more than %d hours in the future.", endDate.Format(time.RFC3339), maxFutureHours)
+		usage()
+	}
 }
 
-//--------------------------------------
-// Setup
-//--------------------------------------
+// Parses a date argument as RFC3339, YYYY-MM-DD or YYYY-MM-DD-HH. Dates
+// without an offset are in the -tz zone. The date is converted to UTC and
+// truncated to the top of the hour.
+func parseDate(s string) (time.Time, error) {
+	var t time.Time
+	var err error
+	for _, layout := range []string{time.RFC3339, "2006-01-02-15", "2006-01-02"} {
+		if t, err = time.ParseInLocation(layout, s, dateLocation); err == nil {
+			return t.UTC().Truncate(time.Hour), nil
+		}
+	}
+	return t, err
+}
 
-// Imports GitHub Archive data for a given hour.
-func importDate(table *sky.Table, date time.Time) error {
-	// Retrieve gziped JSON file.
-	url := fmt.Sprintf("http://data.githubarchive.org/%d-%02d-%02d-%d.json.gz", date.Year(), int(date.Month()), date.Day(), date.Hour())
-	warn("%v", url)
-	resp, err := http.Get(url)
+// Reads the hours to import from a file. Each line is a date in any of the
+// forms accepted on the command line. Blank lines and lines starting with
+// '#' are ignored. The hours are returned in order without duplicates.
+func readHoursFile(path string) ([]time.Time, error) {
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Decompress response.
-	gzipReader, err := gzip.NewReader(resp.Body)
-	defer gzipReader.Close()
-	r := bufio.NewReader(gzipReader)
-	lineNumber := 0
-	for {
-		lineNumber += 1
-
-		line, err := r.ReadBytes('\n')
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			warn("[L%d] %v", lineNumber, err)
-		}
+		return nil, err
+	}
 
-		// Parse data from the stream.
-		data := map[string]interface{}{}
-		if err = json.Unmarshal(line, &data); err != nil {
-			warn("[L%d] %v", lineNumber, err)
+	seen := map[time.Time]bool{}
+	var dates []time.Time
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		date, err := parseDate(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, line)
+		}
+		if !seen[date] {
+			seen[date] = true
+			dates = append(dates, date)
 		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates, nil
+}
 
-		// Create an event.
-		if timestampString, ok := data["created_at"].(string); ok {
-			if timestamp, err := time.Parse(time.RFC3339, timestampString); err == nil {
-				if username, ok := data["actor"].(string); ok && len(username) > 0 {
-					event := sky.NewEvent(timestamp, map[string]interface{}{})
-					event.Data["action"] = data["type"]
-
-					if repository, ok := data["repository"].(map[string]interface{}); ok {
-						event.Data["language"] = repository["language"]
-						event.Data["forks"] = repository["forks"]
-						event.Data["watchers"] = repository["watchers"]
-						event.Data["stargazers"] = repository["stargazers"]
-						event.Data["size"] = repository["size"]
-					}
-
-					table.AddEvent(username, event, sky.Merge)
-				} else if verbose {
-					warn("[L%d] Actor required", lineNumber)
-				}
-			} else if verbose {
-				warn("[L%d] Invalid timestamp: %v (%v)", lineNumber, timestampString, err)
-			}
-		} else if verbose {
-			warn("[L%d] Timestamp required.", lineNumber)
+// Splits a comma-separated list, leaving out blank entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
+	return items
+}
 
-	return nil
+// Reads the users or repositories to import from a comma-separated list
+// and a file with one name per line. Blank lines and lines starting with
+// '#' in the file are ignored.
+func readNames(list string, path string) ([]string, error) {
+	names := splitList(list)
+	if path == "" {
+		return names, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	n := len(names)
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			names = append(names, line)
+		}
+	}
+	if len(names) == n {
+		return nil, fmt.Errorf("no names in %s", path)
+	}
+	return names, nil
 }
 
 //--------------------------------------
-// Utility
+// Logging
 //--------------------------------------
 
-// Writes to standard error.
+// Logs an error.
+func logError(msg string, v ...interface{}) {
+	importer.LogError(msg, v...)
+}
+
+// Logs a warning.
 func warn(msg string, v ...interface{}) {
-	fmt.Fprintf(os.Stderr, msg+"\n", v...)
+	importer.LogWarn(msg, v...)
+}
+
+// Logs an informational message.
+func info(msg string, v ...interface{}) {
+	importer.LogInfo(msg, v...)
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/daemonchen/sky-gharchive-importer/importer"
+	"github.com/skydb/sky.go"
+	"testing"
+)
+
+// Ensures that -property changes existing properties and adds new ones.
+func TestOverrideSchema(t *testing.T) {
+	var overrides propertyFlag
+	for _, s := range []string{"size:Integer:false", "action:String:true", "repo:Factor:true:repo.name"} {
+		if err := overrides.Set(s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+	for _, s := range []string{"size:Integer", "size:Long:true", ":Integer:true", "size:Integer:maybe", "username:Factor:true"} {
+		if err := (&propertyFlag{}).Set(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+
+	base := importer.DefaultSchema()
+	properties, err := importer.OverrideSchema(base, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]*importer.Property{}
+	for _, p := range properties {
+		byName[p.Name] = p
+	}
+	if p := byName["size"]; p.DataType() != sky.Integer || p.Transient || p.Path != "repository.size" {
+		t.Fatalf("Unexpected size: %+v", p)
+	}
+	if p := byName["action"]; p.DataType() != sky.String || !p.Transient {
+		t.Fatalf("Unexpected action: %+v", p)
+	}
+	if p := byName["repo"]; p == nil || p.DataType() != sky.Factor || p.Path != "repo.name" {
+		t.Fatalf("Unexpected repo: %+v", p)
+	}
+	if len(properties) != len(base)+1 {
+		t.Fatalf("Unexpected property count: %d", len(properties))
+	}
+	for _, p := range base {
+		if p.Name == "size" && !p.Transient {
+			t.Fatal("Expected the original schema to be left unchanged")
+		}
+	}
+
+	if _, err := importer.OverrideSchema(importer.DefaultSchema(), propertyFlag{{Name: "stars", Type: sky.Integer}}); err == nil {
+		t.Fatal("Expected an error for a new property without a path")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/daemonchen/sky-gharchive-importer/importer"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// Exit statuses. A run that finishes with hours failed or skipped exits
+// with exitPartial, while a fatal error during setup, or one that aborts
+// the run, exits with exitFatal.
+const (
+	exitOK          = 0
+	exitFatal       = 1
+	exitPartial     = 2
+	exitInterrupted = 130
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// runStatus is the final result of a run as written to -status-file.
+type runStatus struct {
+	Status         string            `json:"status"`
+	ExitCode       int               `json:"exit_code"`
+	Error          string            `json:"error,omitempty"`
+	StartTime      time.Time         `json:"start_time"`
+	EndTime        time.Time         `json:"end_time"`
+	Duration       string            `json:"duration"`
+	HoursTotal     int               `json:"hours_total"`
+	HoursCompleted int               `json:"hours_completed"`
+	HoursFailed    int               `json:"hours_failed"`
+	HoursSkipped   int               `json:"hours_skipped"`
+	FailedHours    []time.Time       `json:"failed_hours"`
+	SkippedHours   []time.Time       `json:"skipped_hours"`
+	EventsRead     int               `json:"events_read"`
+	EventsAdded    int               `json:"events_added"`
+	EventsSkipped  int               `json:"events_skipped"`
+	SkipReasons    map[string]int    `json:"skip_reasons,omitempty"`
+	Args           []string          `json:"args"`
+	Config         map[string]string `json:"config"`
+}
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// Returns the status and exit code for the outcome of a run. An interrupted
+// run takes precedence over an error that aborted it, which takes
+// precedence over hours that failed or were skipped.
+func exitStatus(interrupted bool, err error, incomplete bool) (string, int) {
+	switch {
+	case interrupted:
+		return "interrupted", exitInterrupted
+	case err != nil:
+		return "failed", exitFatal
+	case incomplete:
+		return "partial", exitPartial
+	default:
+		return "ok", exitOK
+	}
+}
+
+// Returns the value of every flag, including defaults. Header flags only
+// report their names so no credentials are included.
+func effectiveConfig(flags *flag.FlagSet) map[string]string {
+	config := map[string]string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = f.Value.String()
+	})
+	return config
+}
+
+// Writes the status of a run to a file as a single JSON object, replacing
+// the file atomically so a reader never sees a partial result.
+func writeStatusFile(path string, status *runStatus) error {
+	b, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	return importer.WriteFileAtomic(path, append(b, '\n'))
+}
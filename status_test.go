@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"testing"
+)
+
+// Ensures that the outcome of a run maps to the right exit status.
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		interrupted bool
+		err         error
+		incomplete  bool
+		status      string
+		code        int
+	}{
+		{"complete", false, nil, false, "ok", exitOK},
+		{"hours failed or skipped", false, nil, true, "partial", exitPartial},
+		{"aborted", false, errors.New("Factor cardinality limit exceeded."), true, "failed", exitFatal},
+		{"interrupted", true, errors.New("context canceled"), true, "interrupted", exitInterrupted},
+	}
+	for _, tt := range tests {
+		status, code := exitStatus(tt.interrupted, tt.err, tt.incomplete)
+		if status != tt.status || code != tt.code {
+			t.Errorf("%s: unexpected status: %s (%d)", tt.name, status, code)
+		}
+	}
+}
+
+// Ensures that the configuration includes defaults and only header names.
+func TestEffectiveConfig(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("concurrency", 1, "")
+	flags.String("table", "gharchive", "")
+	headers := headerFlag{}
+	flags.Var(headers, "header", "")
+	if err := flags.Parse([]string{"-concurrency", "4", "-header", "Authorization: token secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := effectiveConfig(flags)
+	if config["concurrency"] != "4" || config["table"] != "gharchive" {
+		t.Fatalf("Unexpected config: %v", config)
+	}
+	if config["header"] != "Authorization" {
+		t.Fatalf("Unexpected header: %s", config["header"])
+	}
+}